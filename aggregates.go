@@ -0,0 +1,129 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Monthly totals are cached in monthly_aggregates, one row per chat, month,
+// type and status. Triggers on transactions keep the rows of every month
+// listed in monthly_aggregate_months up to date; a month that isn't listed
+// yet is recomputed from transactions the first time it is read.
+
+// rebuildMonthAggregates recomputes one month of the chat's cache inside tx.
+func rebuildMonthAggregates(tx *sql.Tx, chatID int64, month string) error {
+	if _, err := tx.Exec("DELETE FROM monthly_aggregates WHERE chat_id = ? AND month = ?", chatID, month); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO monthly_aggregates (chat_id, month, type, status, total, count)
+		SELECT chat_id, strftime('%Y-%m', created_at), type, status, SUM(amount), COUNT(*) FROM transactions
+		WHERE chat_id = ? AND deleted_at IS NULL AND strftime('%Y-%m', created_at) = ?
+		GROUP BY type, status`, chatID, month); err != nil {
+		return err
+	}
+	_, err := tx.Exec("INSERT OR REPLACE INTO monthly_aggregate_months (chat_id, month, built_at) VALUES (?, ?, ?)",
+		chatID, month, time.Now().In(location).Format(timeLayout))
+	return err
+}
+
+// ensureMonthAggregates fills the cache for a month that hasn't been built.
+func ensureMonthAggregates(chatID int64, month string) error {
+	var built bool
+	if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM monthly_aggregate_months WHERE chat_id = ? AND month = ?)",
+		chatID, month).Scan(&built); err != nil {
+		return err
+	}
+	if built {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := rebuildMonthAggregates(tx, chatID, month); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// queryMonthAggregates returns the month's income, expense and savings
+// totals from the cache. Savings count whatever their status, as in
+// querySavingsTotal. If the cache can't be used the totals are computed
+// from transactions directly.
+func queryMonthAggregates(chatID int64, month time.Time, includePending bool) (income, expense, savings float64, err error) {
+	key := month.Format("2006-01")
+	if err = ensureMonthAggregates(chatID, key); err == nil {
+		err = db.QueryRow(`SELECT
+			COALESCE(SUM(CASE WHEN type = 'income' AND (? OR status = 'cleared') THEN total END), 0),
+			COALESCE(SUM(CASE WHEN type = 'expense' AND (? OR status = 'cleared') THEN total END), 0),
+			COALESCE(SUM(CASE WHEN type = 'savings' THEN total END), 0)
+			FROM monthly_aggregates WHERE chat_id = ? AND month = ?`,
+			includePending, includePending, chatID, key).Scan(&income, &expense, &savings)
+		if err == nil {
+			return income, expense, savings, nil
+		}
+	}
+	log.Printf("Monthly aggregates for %s unavailable, recomputing: %v", key, err)
+
+	end := month.AddDate(0, 1, 0)
+	if income, expense, err = queryTypeTotals(chatID, month, end, includePending); err != nil {
+		return 0, 0, 0, err
+	}
+	savings, err = querySavingsTotal(chatID, month, end)
+	return income, expense, savings, err
+}
+
+// rebuildCache throws away the chat's cached totals and recomputes every
+// month that has transactions.
+func rebuildCache(chatID int64) {
+	months, err := rebuildChatAggregates(chatID)
+	if err != nil {
+		sendMessage(chatID, "Failed to rebuild the summary cache. "+dbErrorHint(err))
+		log.Printf("Cache rebuild error: %v", err)
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("Summary cache rebuilt for %d month(s).", months))
+}
+
+func rebuildChatAggregates(chatID int64) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"monthly_aggregates", "monthly_aggregate_months"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE chat_id = ?", chatID); err != nil {
+			return 0, err
+		}
+	}
+
+	rows, err := tx.Query("SELECT DISTINCT strftime('%Y-%m', created_at) FROM transactions WHERE chat_id = ? AND deleted_at IS NULL", chatID)
+	if err != nil {
+		return 0, err
+	}
+	var months []string
+	for rows.Next() {
+		var month string
+		if err := rows.Scan(&month); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		months = append(months, month)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, month := range months {
+		if err := rebuildMonthAggregates(tx, chatID, month); err != nil {
+			return 0, err
+		}
+	}
+	return len(months), tx.Commit()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// knownCommands lists every command handleMessage understands, so aliases
+// can be checked against them.
+var knownCommands = map[string]bool{
+	"start": true, "add": true, "income": true, "expense": true, "summary": true,
+	"confirm": true, "export": true, "summary_export": true, "purge": true, "lastmonth": true,
+	"get_latest_report": true, "get_weekly_expense": true, "merge_categories": true,
+	"cancel": true, "cancel_all": true, "skip": true, "find_ref": true, "delete": true,
+	"restore": true, "trash": true, "empty_trash": true, "show": true, "pin": true,
+	"convert": true, "note": true, "find_amount": true, "find_by_amount": true, "tag": true,
+	"category": true, "category_trend": true, "recategorize": true, "uncategorized": true,
+	"goal": true, "goals": true, "recurring": true, "recurring_add": true,
+	"suggest_recurring": true, "recurring_delete": true, "profile": true, "settings": true,
+	"set": true, "inference": true, "summary_categories_all": true, "networth": true,
+	"streak": true, "byweekday": true, "whoami": true, "savings_rate": true,
+	"setdate": true, "category_avg": true, "upcoming": true, "quick": true, "quick_add": true,
+	"quick_delete": true, "quick_list": true, "diff": true,
+	"export_category": true, "summary_pie": true,
+	"managecategories": true, "summary_by_description": true,
+	"category_range": true, "query": true, "summary_qr": true,
+	"states": true, "monthly_goal": true,
+	"setcurrency": true, "byhour": true, "by_method": true, "rebuild_cache": true,
+	"merge_duplicates": true, "year": true, "dbinfo": true,
+}
+
+// commandAliases maps a shortcut such as "a" to the command it runs.
+var commandAliases = make(map[string]string)
+
+// parseCommandAliases reads COMMAND_ALIASES, e.g. "a=add,s=summary".
+// Aliases may not shadow a real command and must point to one.
+func parseCommandAliases(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		alias, command, ok := strings.Cut(pair, "=")
+		alias = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(alias), "/"))
+		command = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(command), "/"))
+		if !ok || alias == "" || command == "" {
+			return fmt.Errorf("invalid entry %q, expected alias=command", pair)
+		}
+		if knownCommands[alias] {
+			return fmt.Errorf("alias %q collides with the /%s command", alias, alias)
+		}
+		if !knownCommands[command] {
+			return fmt.Errorf("alias %q points to unknown command %q", alias, command)
+		}
+		if existing, dup := commandAliases[alias]; dup && existing != command {
+			return fmt.Errorf("alias %q is defined twice", alias)
+		}
+		commandAliases[alias] = command
+		log.Printf("Alias /%s -> /%s", alias, command)
+	}
+	return nil
+}
+
+// resolveAlias returns the command an alias stands for, or command itself.
+func resolveAlias(command string) string {
+	if canonical, ok := commandAliases[strings.ToLower(command)]; ok {
+		return canonical
+	}
+	return command
+}
+
+// publicSafeCommands are the only commands PUBLIC_COMMANDS may open to
+// unauthorized users; neither reads nor writes any data.
+var publicSafeCommands = map[string]bool{"whoami": true, "start": true}
+
+// publicCommands may be used without authorization. /whoami is open by
+// default so new users can find the ID to put in ALLOWED_USER_ID.
+var publicCommands = map[string]bool{"whoami": true}
+
+// parsePublicCommands reads PUBLIC_COMMANDS, e.g. "whoami,start".
+func parsePublicCommands(value string) error {
+	commands := make(map[string]bool)
+	for _, command := range strings.Split(value, ",") {
+		command = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(command), "/"))
+		if command == "" {
+			continue
+		}
+		if !publicSafeCommands[command] {
+			return fmt.Errorf("/%s can't be made public; allowed are /whoami and /start", command)
+		}
+		commands[command] = true
+	}
+	publicCommands = commands
+	return nil
+}
+
+// handleUnauthorized answers someone who isn't allowed to use the bot,
+// running only the commands in PUBLIC_COMMANDS.
+func handleUnauthorized(message *tgbotapi.Message) {
+	command := strings.ToLower(message.Command())
+	switch {
+	case command == "whoami" && publicCommands[command]:
+		showWhoAmI(message)
+	case command == "start" && publicCommands[command]:
+		text := UNAUTHORIZED_MESSAGE
+		if publicCommands["whoami"] {
+			text += "\n\nSend /whoami to get the ID the bot owner needs to give you access."
+		}
+		sendMessage(message.Chat.ID, text)
+	default:
+		sendMessage(message.Chat.ID, UNAUTHORIZED_MESSAGE)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxAmount caps a single transaction so a typo in an expression can't log an absurd value.
+const maxAmount = 1000000000000
+
+// parseAmount evaluates a plain number or a simple arithmetic expression
+// such as "15000+20000*2". Only positive numbers and the +, - and *
+// operators are accepted; multiplication binds tighter than addition.
+// With WHOLE_NUMBER_AMOUNTS enabled, numbers with decimals are rejected.
+func parseAmount(text string) (float64, error) {
+	expr := strings.ReplaceAll(normalizeAmountInput(text), " ", "")
+	if expr == "" {
+		return 0, errors.New("empty amount")
+	}
+
+	total := 0.0
+	term := 0.0
+	sign := 1.0
+	haveTerm := false
+	pendingMul := false
+
+	for i := 0; ; {
+		// Read the next number
+		j := i
+		for j < len(expr) && (expr[j] >= '0' && expr[j] <= '9' || expr[j] == '.') {
+			j++
+		}
+		if j == i {
+			return 0, errors.New("expected a number")
+		}
+		if WHOLE_NUMBER_AMOUNTS && strings.Contains(expr[i:j], ".") {
+			return 0, errors.New("decimals are not allowed")
+		}
+		n, err := strconv.ParseFloat(expr[i:j], 64)
+		if err != nil {
+			return 0, err
+		}
+
+		if pendingMul {
+			term *= n
+		} else {
+			term = n
+		}
+		haveTerm = true
+
+		if j == len(expr) {
+			break
+		}
+
+		switch expr[j] {
+		case '*', 'x':
+			pendingMul = true
+		case '+', '-':
+			total += sign * term
+			haveTerm = false
+			pendingMul = false
+			if expr[j] == '-' {
+				sign = -1
+			} else {
+				sign = 1
+			}
+		default:
+			return 0, errors.New("unsupported character in amount")
+		}
+		i = j + 1
+		if i == len(expr) {
+			return 0, errors.New("expression ends with an operator")
+		}
+	}
+
+	if haveTerm {
+		total += sign * term
+	}
+	return total, nil
+}
+
+// parseQuantityEntry recognises a "quantity x unit price" entry such as
+// "3x25000" or "1.5 × 20000" and returns the total with a breakdown to keep
+// in the description. The quantity may be fractional even with
+// WHOLE_NUMBER_AMOUNTS, in which case the total is rounded to whole units.
+// ok is false when text isn't a quantity entry at all.
+func parseQuantityEntry(text string) (amount float64, breakdown string, ok bool, err error) {
+	expr := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(text)), "×", "x")
+	i := strings.IndexAny(expr, "x*")
+	if i < 0 {
+		return 0, "", false, nil
+	}
+	quantityText, priceText := strings.TrimSpace(expr[:i]), strings.TrimSpace(expr[i+1:])
+	quantity, qerr := strconv.ParseFloat(quantityText, 64)
+	// Anything more complex is left to parseAmount
+	if qerr != nil || strings.ContainsAny(priceText, "x*+-") {
+		return 0, "", false, nil
+	}
+
+	price, err := parseAmount(priceText)
+	if err != nil {
+		return 0, "", true, err
+	}
+	if quantity <= 0 || price <= 0 {
+		return 0, "", true, errors.New("quantity and unit price must be positive")
+	}
+	amount = quantity * price
+	if WHOLE_NUMBER_AMOUNTS {
+		amount = math.Round(amount)
+	}
+	return amount, strconv.FormatFloat(quantity, 'f', -1, 64) + " × " + formatNumber(price), true, nil
+}
+
+// roundEntryAmount rounds a typed amount to ENTRY_DECIMALS places before
+// it is stored. A negative ENTRY_DECIMALS keeps the amount as typed.
+func roundEntryAmount(amount float64) float64 {
+	if ENTRY_DECIMALS < 0 {
+		return amount
+	}
+	scale := math.Pow(10, float64(ENTRY_DECIMALS))
+	return math.Round(amount*scale) / scale
+}
+
+// numberToken matches a run of digits with commas or dots inside it, and
+// groupedNumber one that is laid out in thousands groups, as in "25,000" or
+// "1.250.000".
+var (
+	numberToken   = regexp.MustCompile(`\d[\d,.]*\d`)
+	groupedNumber = regexp.MustCompile(`^[1-9]\d{0,2}([,.]\d{3})+$`)
+)
+
+// stripThousandsSeparators turns "1,250,000" or "1.250.000" into "1250000".
+// Only numbers grouped with a single kind of separator are touched. A
+// lone dot group, as in "12.345", reads as a decimal unless it is "000",
+// so "Rp 50.000" still means fifty thousand.
+func stripThousandsSeparators(text string) string {
+	return numberToken.ReplaceAllStringFunc(text, func(token string) string {
+		if !groupedNumber.MatchString(token) || strings.Contains(token, ",") && strings.Contains(token, ".") {
+			return token
+		}
+		groups := strings.FieldsFunc(token, func(r rune) bool { return r == ',' || r == '.' })
+		if len(groups) == 2 && strings.Contains(token, ".") && groups[1] != "000" {
+			return token
+		}
+		return strings.Join(groups, "")
+	})
+}
+
+// normalizeAmountInput cleans up amounts pasted from other apps: it keeps
+// the first line that contains a digit, drops any prefix before the first
+// digit (such as "IDR "), removes thousands separators and cuts off a
+// trailing suffix such as " IDR". A line that would lose digits to the cut,
+// like "50,5", is returned whole so parseAmount rejects it instead of
+// saving part of the number.
+func normalizeAmountInput(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		start := strings.IndexAny(line, "0123456789")
+		if start < 0 {
+			continue
+		}
+		// Keep a leading minus so negative amounts are still rejected
+		if start > 0 && line[start-1] == '-' {
+			start--
+		}
+		line = stripThousandsSeparators(line[start:])
+		end := strings.IndexFunc(line, func(r rune) bool {
+			return !strings.ContainsRune("0123456789.+-*x ", r)
+		})
+		if end >= 0 && strings.IndexAny(line[end:], "0123456789") < 0 {
+			line = line[:end]
+		}
+		return strings.TrimSpace(line)
+	}
+	return strings.TrimSpace(text)
+}
+
+// formatAmount renders an amount for display, prefixed with
+// CURRENCY_SYMBOL when one is set. Symbols ending in a letter, such as
+// "Rp", are separated from the number by a space.
+func formatAmount(amount float64) string {
+	if CURRENCY_SYMBOL == "" {
+		return formatNumber(amount)
+	}
+	prefix := CURRENCY_SYMBOL
+	if r, _ := utf8.DecodeLastRuneInString(prefix); unicode.IsLetter(r) {
+		prefix += " "
+	}
+	if amount < 0 {
+		return "-" + prefix + formatNumber(-amount)
+	}
+	return prefix + formatNumber(amount)
+}
+
+// formatNumber renders an amount without a currency symbol using
+// ROUNDING_MODE and DISPLAY_DECIMALS so every report rounds the same way.
+// WHOLE_NUMBER_AMOUNTS overrides DISPLAY_DECIMALS with 0.
+func formatNumber(amount float64) string {
+	decimals := DISPLAY_DECIMALS
+	if WHOLE_NUMBER_AMOUNTS {
+		decimals = 0
+	}
+	scale := math.Pow(10, float64(decimals))
+	scaled := amount * scale
+	// Absorb float noise such as 1499999.9999999 before rounding up or down
+	if r := math.Round(scaled); math.Abs(scaled-r) < 1e-6 {
+		scaled = r
+	}
+
+	switch ROUNDING_MODE {
+	case "up":
+		scaled = math.Ceil(scaled)
+	case "down":
+		scaled = math.Floor(scaled)
+	default:
+		scaled = math.Round(scaled)
+	}
+	return strconv.FormatFloat(scaled/scale, 'f', decimals, 64)
+}
+
+// validateCurrencySymbol accepts short symbols or codes such as "Rp",
+// "$" or "IDR".
+func validateCurrencySymbol(symbol string) error {
+	n := utf8.RuneCountInString(symbol)
+	if n == 0 || n > 5 {
+		return errors.New("must be 1 to 5 characters")
+	}
+	if strings.ContainsAny(symbol, "0123456789 \n") {
+		return errors.New("can't contain digits or spaces")
+	}
+	return nil
+}
+
+// invalidAmountMessage explains what the amount step accepts.
+func invalidAmountMessage() string {
+	if WHOLE_NUMBER_AMOUNTS {
+		return "Invalid amount. Please enter a positive whole number or a sum like 15000+20000."
+	}
+	return "Invalid amount. Please enter a positive number or a sum like 15000+20000."
+}
@@ -0,0 +1,79 @@
+package main
+
+import "testing"
+
+func TestNormalizeAmountInput(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"50000\n", "50000"},
+		{" 50000 ", "50000"},
+		{"IDR 50000", "50000"},
+		{"50000 IDR", "50000"},
+		{"\n\n50000\nthanks", "50000"},
+		{"Transfer\nRp 75.000\nRef 123", "75000"},
+		{"50,000", "50000"},
+		{"Rp 50.000", "50000"},
+		{"1,250,000", "1250000"},
+		{"1.250.000", "1250000"},
+		{"12.5", "12.5"},
+		{"15000+20.000", "15000+20000"},
+		// Would lose digits if cut, so it is left for parseAmount to reject
+		{"50,5", "50,5"},
+		{"Rp50.000,00", "50.000,00"},
+		// Decimals with three fractional digits are not thousands groups
+		{"12.345", "12.345"},
+		{"0.125", "0.125"},
+		{"5000.500", "5000.500"},
+		{"1,250.50", "1,250.50"},
+		{"12,345", "12345"},
+	}
+	for _, tt := range tests {
+		if got := normalizeAmountInput(tt.in); got != tt.want {
+			t.Errorf("normalizeAmountInput(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+	}{
+		{"50000\n", 50000},
+		{" 50000 ", 50000},
+		{"IDR 50000", 50000},
+		{"50,000", 50000},
+		{"Rp 50.000", 50000},
+		{"1,250,000", 1250000},
+		{"12.5", 12.5},
+		{"15000+20000*2", 55000},
+		{"3x25.000", 75000},
+		{"5000.500", 5000.5},
+		{"1.250.000", 1250000},
+	}
+	for _, tt := range tests {
+		got, err := parseAmount(tt.in)
+		if err != nil {
+			t.Errorf("parseAmount(%q) returned error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseAmount(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "abc", "50,5", "Rp50.000,00", "-5", "5+"} {
+		if got, err := parseAmount(in); err == nil {
+			t.Errorf("parseAmount(%q) = %v, want an error", in, got)
+		}
+	}
+	saved := WHOLE_NUMBER_AMOUNTS
+	defer func() { WHOLE_NUMBER_AMOUNTS = saved }()
+	WHOLE_NUMBER_AMOUNTS = true
+	for _, in := range []string{"5000.500", "12.345", "0.125"} {
+		if got, err := parseAmount(in); err == nil {
+			t.Errorf("parseAmount(%q) with WHOLE_NUMBER_AMOUNTS = %v, want an error", in, got)
+		}
+	}
+}
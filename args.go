@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// commandArgs reads the arguments of a command one at a time. The first
+// failure is kept and later reads become no-ops, so a handler can read
+// everything it needs and then check ok once:
+//
+//	cmd := newCommandArgs(args, "/delete <id>")
+//	id := cmd.id()
+//	if !cmd.ok(chatID) {
+//		return
+//	}
+type commandArgs struct {
+	fields []string
+	usage  string
+	err    error
+}
+
+func newCommandArgs(args string, usage string) *commandArgs {
+	return &commandArgs{fields: strings.Fields(args), usage: usage}
+}
+
+// next returns the next raw argument, failing with the usage when there
+// are none left.
+func (a *commandArgs) next() string {
+	if a.err != nil {
+		return ""
+	}
+	if len(a.fields) == 0 {
+		a.err = errors.New("missing argument")
+		return ""
+	}
+	field := a.fields[0]
+	a.fields = a.fields[1:]
+	return field
+}
+
+// has reports whether any arguments are left to read.
+func (a *commandArgs) has() bool {
+	return a.err == nil && len(a.fields) > 0
+}
+
+// id reads a transaction or template id such as "12" or "#12".
+func (a *commandArgs) id() int64 {
+	field := a.next()
+	if a.err != nil {
+		return 0
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(field, "#"), 10, 64)
+	if err != nil || id <= 0 {
+		a.err = fmt.Errorf("%q is not a valid id", field)
+		return 0
+	}
+	return id
+}
+
+// date reads a YYYY-MM-DD date in the configured timezone.
+func (a *commandArgs) date() time.Time {
+	field := a.next()
+	if a.err != nil {
+		return time.Time{}
+	}
+	date, err := time.ParseInLocation("2006-01-02", field, location)
+	if err != nil {
+		a.err = fmt.Errorf("%q is not a YYYY-MM-DD date", field)
+	}
+	return date
+}
+
+// month reads a YYYY-MM month and returns its first day.
+func (a *commandArgs) month() time.Time {
+	field := a.next()
+	if a.err != nil {
+		return time.Time{}
+	}
+	month, err := time.ParseInLocation("2006-01", field, location)
+	if err != nil {
+		a.err = fmt.Errorf("%q is not a YYYY-MM month", field)
+	}
+	return month
+}
+
+// category reads a configured category name, ignoring case.
+func (a *commandArgs) category() string {
+	field := a.next()
+	if a.err != nil {
+		return ""
+	}
+	category, ok := findCategory(field)
+	if !ok {
+		a.err = errors.New(unknownCategoryMessage(field))
+	}
+	return category
+}
+
+// rest returns the remaining arguments joined by spaces.
+func (a *commandArgs) rest() string {
+	text := strings.Join(a.fields, " ")
+	a.fields = nil
+	return text
+}
+
+// ok reports whether every argument was valid and none were left over.
+// Otherwise it sends the problem and the usage to the chat.
+func (a *commandArgs) ok(chatID int64) bool {
+	if a.err == nil && len(a.fields) > 0 {
+		a.err = fmt.Errorf("unexpected %q", strings.Join(a.fields, " "))
+	}
+	if a.err == nil {
+		return true
+	}
+	sendMessage(chatID, fmt.Sprintf("%v\nUsage: %s", a.err, a.usage))
+	return false
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// blockedChats holds chats whose user has blocked the bot. Scheduled
+// messages to them are skipped until the user writes again.
+var (
+	blockedMu    sync.Mutex
+	blockedChats = make(map[int64]bool)
+)
+
+// isBlockedError reports whether Telegram refused a send because the
+// user blocked the bot or the bot can no longer post in the chat.
+func isBlockedError(err error) bool {
+	var apiErr *tgbotapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusForbidden
+}
+
+// noteSendError suspends scheduled messages to chatID when err says the
+// bot was blocked.
+func noteSendError(chatID int64, err error) {
+	if !isBlockedError(err) {
+		return
+	}
+	blockedMu.Lock()
+	defer blockedMu.Unlock()
+	if !blockedChats[chatID] {
+		log.Printf("Chat %d blocked the bot; suspending scheduled messages until it writes again", chatID)
+		blockedChats[chatID] = true
+	}
+}
+
+// resumeChat lifts the suspension once the chat sends something.
+func resumeChat(chatID int64) {
+	blockedMu.Lock()
+	defer blockedMu.Unlock()
+	if blockedChats[chatID] {
+		log.Printf("Chat %d is back; resuming scheduled messages", chatID)
+		delete(blockedChats, chatID)
+	}
+}
+
+func isChatBlocked(chatID int64) bool {
+	blockedMu.Lock()
+	defer blockedMu.Unlock()
+	return blockedChats[chatID]
+}
+
+// sendScheduledMessage is sendMessage for background jobs, which skips
+// chats that have blocked the bot.
+func sendScheduledMessage(chatID int64, text string) {
+	if isChatBlocked(chatID) {
+		return
+	}
+	sendMessage(chatID, text)
+}
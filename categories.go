@@ -0,0 +1,342 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// checkCategoryLimit rejects category counts above MAX_CATEGORIES, which
+// would make the keyboard unusable and overflow message limits.
+func checkCategoryLimit(count int) error {
+	if count > MAX_CATEGORIES {
+		return fmt.Errorf("%d categories exceeds the maximum of %d", count, MAX_CATEGORIES)
+	}
+	return nil
+}
+
+// findCategory returns the configured category matching name, ignoring case.
+func findCategory(name string) (string, bool) {
+	for _, category := range categories {
+		if strings.EqualFold(category, name) {
+			return category, true
+		}
+	}
+	return "", false
+}
+
+func removeCategory(name string) {
+	for i, category := range categories {
+		if category == name {
+			categories = append(categories[:i], categories[i+1:]...)
+			return
+		}
+	}
+}
+
+func mergeCategories(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		sendMessage(chatID, "Usage: /merge_categories <source> <target>")
+		return
+	}
+
+	source, ok := findCategory(fields[0])
+	if !ok {
+		sendMessage(chatID, unknownCategoryMessage(fields[0]))
+		return
+	}
+	target, ok := findCategory(fields[1])
+	if !ok {
+		sendMessage(chatID, unknownCategoryMessage(fields[1]))
+		return
+	}
+	if source == target {
+		sendMessage(chatID, "Source and target must be different categories.")
+		return
+	}
+
+	// Categories are shared by every chat, so the merge applies to all of
+	// them; main.go only lets the owner run it
+	moved, err := mergeCategoryRows(source, target)
+	if err != nil {
+		sendMessage(chatID, "Failed to merge categories.")
+		log.Printf("Database exec error: %v", err)
+		return
+	}
+
+	removeCategory(source)
+	if err := saveCategories(); err != nil {
+		log.Printf("Database exec error: %v", err)
+	}
+	log.Printf("Merged category %s into %s (%d rows)", source, target, moved)
+	sendMessage(chatID, fmt.Sprintf("Merged %s into %s: %d transaction(s) moved.", source, target, moved))
+}
+
+// mergeCategoryRows moves everything filed under source to target, in the
+// same tables a rename from /managecategories updates, and returns how many
+// transactions moved. A range already set for target wins over source's.
+func mergeCategoryRows(source, target string) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE transactions SET category = ? WHERE category = ?", target, source)
+	if err != nil {
+		return 0, err
+	}
+	moved, _ := result.RowsAffected()
+	for _, query := range []string{
+		"UPDATE recurring SET category = ? WHERE category = ?",
+		"UPDATE quick_entries SET category = ? WHERE category = ?",
+		"UPDATE OR IGNORE category_ranges SET category = ? WHERE category = ?",
+	} {
+		if _, err := tx.Exec(query, target, source); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM category_ranges WHERE category = ?", source); err != nil {
+		return 0, err
+	}
+	return moved, tx.Commit()
+}
+
+// startAssignCategories walks the user through every transaction whose
+// category is blank or no longer configured, one row at a time.
+func startAssignCategories(chatID int64, userID int64) {
+	transactions, err := queryTransactions("SELECT "+transactionColumns+" FROM transactions WHERE chat_id = ? AND deleted_at IS NULL AND type != 'savings' ORDER BY created_at", chatID)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+
+	var queue []int64
+	for _, t := range transactions {
+		if _, ok := findCategory(t.Category); !ok {
+			queue = append(queue, t.ID)
+		}
+	}
+	if len(queue) == 0 {
+		sendMessage(chatID, "Every transaction has a known category.")
+		return
+	}
+
+	state := &TransactionState{
+		ChatID: chatID,
+		UserID: userID,
+		Step:   "ASSIGN_CATEGORY",
+		Queue:  queue,
+	}
+	userStates[stateKey{chatID, userID}] = state
+	sendMessage(chatID, fmt.Sprintf("Found %d uncategorized transaction(s).", len(queue)))
+	promptAssignCategory(chatID, 0, state)
+}
+
+// promptAssignCategory shows the next queued row, editing messageID in
+// place when it is non-zero.
+func promptAssignCategory(chatID int64, messageID int, state *TransactionState) {
+	total := state.Processed + len(state.Queue)
+	if len(state.Queue) == 0 {
+		clearState(state)
+		text := fmt.Sprintf("Done: %d of %d categorized.", state.Processed, total)
+		if messageID != 0 {
+			editMessage(chatID, messageID, text)
+		} else {
+			sendMessage(chatID, text)
+		}
+		return
+	}
+
+	state.TransactionID = state.Queue[0]
+	transactions, err := queryTransactions("SELECT "+transactionColumns+" FROM transactions WHERE id = ?", state.TransactionID)
+	if err != nil || len(transactions) == 0 {
+		log.Printf("Failed to load transaction %d: %v", state.TransactionID, err)
+		state.Queue = state.Queue[1:]
+		promptAssignCategory(chatID, messageID, state)
+		return
+	}
+
+	state.Description = transactions[0].Description
+	state.Suggested, _ = inferCategory(chatID, state.Description)
+
+	buttons := categoryButtons()
+	prompt := "Choose a category:"
+	if state.Suggested != "" {
+		buttons = append([][]tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✓ "+state.Suggested, state.Suggested),
+		)}, buttons...)
+		prompt = fmt.Sprintf("Suggested: %s. Confirm it or choose another category:", state.Suggested)
+	}
+	buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Skip", "assign:skip"),
+		tgbotapi.NewInlineKeyboardButtonData("Stop", "assign:stop"),
+	))
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(buttons...)
+	text := fmt.Sprintf("%d of %d categorized.\n\n%s%s", state.Processed, total, formatTransactionList(transactions), prompt)
+	if messageID != 0 {
+		editMessageWithKeyboard(chatID, messageID, text, keyboard)
+	} else {
+		sendMessageWithKeyboard(chatID, text, keyboard)
+	}
+}
+
+func processAssignCategory(callback *tgbotapi.CallbackQuery, state *TransactionState) {
+	chatID := callback.Message.Chat.ID
+
+	switch callback.Data {
+	case "assign:stop":
+		clearState(state)
+		editMessage(chatID, callback.Message.MessageID, fmt.Sprintf("Stopped: %d categorized, %d left.", state.Processed, len(state.Queue)))
+		answerCallback(callback, "")
+		return
+	case "assign:skip":
+		state.Queue = state.Queue[1:]
+		promptAssignCategory(chatID, callback.Message.MessageID, state)
+		answerCallback(callback, "Skipped")
+		return
+	}
+
+	category, ok := findCategory(callback.Data)
+	if !ok {
+		answerCallback(callback, "Unknown category")
+		return
+	}
+	if _, err := db.Exec("UPDATE transactions SET category = ? WHERE id = ?", category, state.TransactionID); err != nil {
+		answerCallback(callback, "Failed to update transaction")
+		log.Printf("Database exec error: %v", err)
+		return
+	}
+	recordCategoryChoice(chatID, state.Description, state.Suggested, category)
+
+	state.Processed++
+	state.Queue = state.Queue[1:]
+	promptAssignCategory(chatID, callback.Message.MessageID, state)
+	answerCallback(callback, "Category assigned")
+}
+
+// processTypedCategory lets the user type a category name instead of
+// finding its button, which helps with long category lists. An exact match
+// is selected right away; close matches are offered as buttons to confirm.
+func processTypedCategory(message *tgbotapi.Message, state *TransactionState) {
+	chatID := message.Chat.ID
+	name := strings.TrimSpace(message.Text)
+	if category, ok := findCategory(name); ok {
+		state.Category = category
+		state.Step = "ENTER_AMOUNT"
+		sendMessage(chatID, fmt.Sprintf("Selected category: %s. Enter the transaction amount.", category))
+		return
+	}
+
+	var matches []string
+	if name != "" {
+		matches = suggestCategories(name)
+	}
+	if len(matches) == 0 {
+		sendMessage(chatID, fmt.Sprintf("No category matches %q. Type another name or use the buttons above.", name))
+		return
+	}
+
+	buttons := make([][]tgbotapi.InlineKeyboardButton, 0, len(matches))
+	for _, category := range matches {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(category, category)))
+	}
+	text := fmt.Sprintf("Several categories match %q. Choose one:", name)
+	if len(matches) == 1 {
+		text = fmt.Sprintf("Did you mean %s? Tap to confirm or type another name.", matches[0])
+	}
+	sendMessageWithKeyboard(chatID, text, tgbotapi.NewInlineKeyboardMarkup(buttons...))
+}
+
+// suggestCategories returns categories that look like a typo of name.
+func suggestCategories(name string) []string {
+	name = strings.ToLower(name)
+	var matches []string
+	for _, category := range categories {
+		lower := strings.ToLower(category)
+		if strings.Contains(lower, name) || strings.Contains(name, lower) || levenshtein(lower, name) <= 2 {
+			matches = append(matches, category)
+		}
+	}
+	return matches
+}
+
+// unknownCategoryMessage explains that name isn't a category, offering
+// close matches when there are any.
+func unknownCategoryMessage(name string) string {
+	if matches := suggestCategories(name); len(matches) > 0 {
+		return fmt.Sprintf("Unknown category: %s. Did you mean %s?", name, strings.Join(matches, ", "))
+	}
+	return fmt.Sprintf("Unknown category: %s. Available: %s", name, strings.Join(categories, ", "))
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func showCategoryTransactions(chatID int64, args string) {
+	fields := strings.Fields(args)
+	where := "chat_id = ? AND deleted_at IS NULL AND category = ?"
+	label := "all time"
+	var periodArgs []interface{}
+	if len(fields) > 1 {
+		if month, err := time.ParseInLocation("2006-01", fields[len(fields)-1], location); err == nil {
+			where += " AND created_at >= ? AND created_at < ?"
+			periodArgs = []interface{}{month.Format(timeLayout), month.AddDate(0, 1, 0).Format(timeLayout)}
+			label = month.Format("January 2006")
+			fields = fields[:len(fields)-1]
+		}
+	}
+	if len(fields) == 0 {
+		sendMessage(chatID, "Usage: /category <name> [YYYY-MM]")
+		return
+	}
+	category, ok := findCategory(strings.Join(fields, " "))
+	if !ok {
+		sendMessage(chatID, unknownCategoryMessage(strings.Join(fields, " ")))
+		return
+	}
+
+	transactions, err := queryTransactions(
+		"SELECT "+transactionColumns+" FROM transactions WHERE "+where+" ORDER BY created_at",
+		append([]interface{}{chatID, category}, periodArgs...)...,
+	)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	if len(transactions) == 0 {
+		sendMessage(chatID, fmt.Sprintf("No %s transactions for %s.", category, label))
+		return
+	}
+
+	total := 0.0
+	for _, t := range transactions {
+		total += t.Amount
+	}
+	sendLongMessage(chatID, fmt.Sprintf("%s transactions (%s):\n\n%s\nTotal: %s",
+		category, label, formatTransactionList(transactions), formatAmount(total)))
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxCategoryName keeps "cat:rename:<name>" within Telegram's 64 byte
+// callback data limit.
+const maxCategoryName = 48
+
+// loadStoredCategories replaces the CATEGORIES list with the one saved
+// from /managecategories, if there is one.
+func loadStoredCategories() {
+	stored := getSetting(globalSettingsUser, "categories", "")
+	if stored == "" {
+		return
+	}
+	categories = strings.Split(stored, ",")
+	log.Printf("Using %d categories saved with /managecategories", len(categories))
+}
+
+// saveCategories stores the current list so changes survive a restart.
+// Once saved, the list takes precedence over CATEGORIES.
+func saveCategories() error {
+	return setSetting(globalSettingsUser, "categories", strings.Join(categories, ","))
+}
+
+func validateCategoryName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("the name can't be empty")
+	case strings.Contains(name, ","):
+		return errors.New("the name can't contain a comma")
+	case len(name) > maxCategoryName:
+		return fmt.Errorf("the name must be at most %d bytes", maxCategoryName)
+	}
+	return nil
+}
+
+func startManageCategories(chatID int64, userID int64) {
+	state := &TransactionState{
+		ChatID: chatID,
+		UserID: userID,
+		Step:   "MANAGE_CATEGORIES",
+	}
+	userStates[stateKey{chatID, userID}] = state
+	showCategoryMenu(chatID, 0, "")
+}
+
+// showCategoryMenu lists the categories with the management actions,
+// editing messageID in place when it is non-zero.
+func showCategoryMenu(chatID int64, messageID int, notice string) {
+	text := ""
+	if notice != "" {
+		text = notice + "\n\n"
+	}
+	text += fmt.Sprintf("Categories (%d of %d):\n", len(categories), MAX_CATEGORIES)
+	for _, category := range categories {
+		text += "• " + category + "\n"
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("➕ Add", "cat:add"),
+			tgbotapi.NewInlineKeyboardButtonData("✏️ Rename", "cat:rename"),
+			tgbotapi.NewInlineKeyboardButtonData("🗑 Delete", "cat:delete"),
+		),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Done", "cat:done")),
+	)
+	if messageID == 0 || editMessageWithKeyboard(chatID, messageID, text, keyboard) != nil {
+		sendMessageWithKeyboard(chatID, text, keyboard)
+	}
+}
+
+// categoryPicker lists every category as a button whose data is prefix
+// followed by the name.
+func categoryPicker(prefix string) tgbotapi.InlineKeyboardMarkup {
+	buttons := make([][]tgbotapi.InlineKeyboardButton, 0, len(categories)+1)
+	for _, category := range categories {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(category, prefix+category)))
+	}
+	buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("« Back", "cat:back")))
+	return tgbotapi.NewInlineKeyboardMarkup(buttons...)
+}
+
+func processManageCategories(callback *tgbotapi.CallbackQuery, state *TransactionState) {
+	chatID := callback.Message.Chat.ID
+	messageID := callback.Message.MessageID
+
+	switch {
+	case callback.Data == "cat:add":
+		if err := checkCategoryLimit(len(categories) + 1); err != nil {
+			answerCallback(callback, "Category limit reached")
+			return
+		}
+		state.Step = "CATEGORY_ADD"
+		editMessage(chatID, messageID, "Send the name of the new category, or /cancel.")
+	case callback.Data == "cat:rename":
+		editMessageWithKeyboard(chatID, messageID, "Choose the category to rename:", categoryPicker("cat:rename:"))
+	case callback.Data == "cat:delete":
+		editMessageWithKeyboard(chatID, messageID, "Choose the category to delete:", categoryPicker("cat:delete:"))
+	case callback.Data == "cat:back":
+		showCategoryMenu(chatID, messageID, "")
+	case callback.Data == "cat:done":
+		clearState(state)
+		editMessage(chatID, messageID, fmt.Sprintf("Categories: %s", strings.Join(categories, ", ")))
+	case strings.HasPrefix(callback.Data, "cat:rename:"):
+		category, ok := findCategory(strings.TrimPrefix(callback.Data, "cat:rename:"))
+		if !ok {
+			answerCallback(callback, "Unknown category")
+			return
+		}
+		state.Step = "CATEGORY_RENAME"
+		state.Category = category
+		editMessage(chatID, messageID, fmt.Sprintf("Send the new name for %s, or /cancel.", category))
+	case strings.HasPrefix(callback.Data, "cat:delete:"):
+		category, ok := findCategory(strings.TrimPrefix(callback.Data, "cat:delete:"))
+		if !ok {
+			answerCallback(callback, "Unknown category")
+			return
+		}
+		if len(categories) == 1 {
+			answerCallback(callback, "Keep at least one category")
+			return
+		}
+		removeCategory(category)
+		if err := saveCategories(); err != nil {
+			categories = append(categories, category)
+			answerCallback(callback, "Failed to save categories")
+			log.Printf("Database exec error: %v", err)
+			return
+		}
+		log.Printf("Deleted category %s", category)
+		showCategoryMenu(chatID, messageID, fmt.Sprintf("Deleted %s. Its transactions keep the name and show up in /uncategorized.", category))
+	default:
+		answerCallback(callback, "Unknown action")
+		return
+	}
+	answerCallback(callback, "")
+}
+
+// processCategoryName handles the name typed after ➕ Add or ✏️ Rename.
+func processCategoryName(message *tgbotapi.Message, state *TransactionState) {
+	chatID := message.Chat.ID
+	name := strings.TrimSpace(message.Text)
+	if err := validateCategoryName(name); err != nil {
+		sendMessage(chatID, fmt.Sprintf("Invalid name: %v. Try again or /cancel.", err))
+		return
+	}
+	if existing, ok := findCategory(name); ok && existing != state.Category {
+		sendMessage(chatID, fmt.Sprintf("%s already exists. Try another name or /cancel.", existing))
+		return
+	}
+
+	previous := append([]string(nil), categories...)
+	var notice string
+	if state.Step == "CATEGORY_ADD" {
+		categories = append(categories, name)
+		notice = fmt.Sprintf("Added %s.", name)
+	} else {
+		for i, category := range categories {
+			if category == state.Category {
+				categories[i] = name
+			}
+		}
+		notice = fmt.Sprintf("Renamed %s to %s.", state.Category, name)
+	}
+	if err := saveCategories(); err != nil {
+		categories = previous
+		sendMessage(chatID, "Failed to save categories. Try again or /cancel.")
+		log.Printf("Database exec error: %v", err)
+		return
+	}
+
+	// Existing rows in every chat follow a rename, like /merge_categories;
+	// only the owner can get here
+	if state.Step == "CATEGORY_RENAME" {
+		for _, table := range []string{"transactions", "recurring", "quick_entries", "category_ranges"} {
+			result, err := db.Exec("UPDATE "+table+" SET category = ? WHERE category = ?", name, state.Category)
+			if err != nil {
+				log.Printf("Database exec error: %v", err)
+				notice += fmt.Sprintf(" Failed to update %s; use /merge_categories to move them.", table)
+				continue
+			}
+			if n, _ := result.RowsAffected(); n > 0 && table == "transactions" {
+				notice += fmt.Sprintf(" %d transaction(s) updated.", n)
+			}
+		}
+	}
+	log.Print(notice)
+
+	state.Step = "MANAGE_CATEGORIES"
+	state.Category = ""
+	showCategoryMenu(chatID, 0, notice)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+)
+
+const (
+	chartWidth   = 800
+	chartHeight  = 400
+	chartPadding = 20
+)
+
+// renderLineChart plots values left to right as a PNG line chart. A grey
+// line marks zero when the series crosses it.
+func renderLineChart(values []float64) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, chartHeight))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	min, max := 0.0, 0.0
+	for i, v := range values {
+		if i == 0 || v < min {
+			min = v
+		}
+		if i == 0 || v > max {
+			max = v
+		}
+	}
+	if max == min {
+		max, min = max+1, min-1
+	}
+
+	plotW := float64(chartWidth - 2*chartPadding)
+	plotH := float64(chartHeight - 2*chartPadding)
+	toY := func(v float64) int {
+		return chartPadding + int((max-v)/(max-min)*plotH)
+	}
+	toX := func(i int) int {
+		if len(values) < 2 {
+			return chartPadding
+		}
+		return chartPadding + int(float64(i)/float64(len(values)-1)*plotW)
+	}
+
+	axis := color.RGBA{160, 160, 160, 255}
+	drawLine(img, chartPadding, chartHeight-chartPadding, chartWidth-chartPadding, chartHeight-chartPadding, axis)
+	drawLine(img, chartPadding, chartPadding, chartPadding, chartHeight-chartPadding, axis)
+	if min < 0 && max > 0 {
+		drawLine(img, chartPadding, toY(0), chartWidth-chartPadding, toY(0), axis)
+	}
+
+	line := color.RGBA{30, 110, 220, 255}
+	for i := 1; i < len(values); i++ {
+		x0, y0, x1, y1 := toX(i-1), toY(values[i-1]), toX(i), toY(values[i])
+		drawLine(img, x0, y0, x1, y1, line)
+		drawLine(img, x0, y0+1, x1, y1+1, line)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// drawLine draws a one pixel line using Bresenham's algorithm.
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.Color) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		img.Set(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// confirmCodeWindow is how long a confirmation code stays valid.
+	confirmCodeWindow = 2 * time.Minute
+	// confirmCodeAttempts is how many wrong codes end the confirmation.
+	confirmCodeAttempts = 3
+)
+
+// confirmation is a sensitive action waiting for its code at the
+// CONFIRM_CODE step.
+type confirmation struct {
+	code     string
+	label    string
+	expires  time.Time
+	attempts int
+	run      func()
+}
+
+// logAudit records a sensitive action in audit_log. Failures are only
+// logged so they never block the action itself.
+func logAudit(chatID int64, userID int64, action, detail string) {
+	if _, err := db.Exec("INSERT INTO audit_log (chat_id, user_id, action, detail, created_at) VALUES (?, ?, ?, ?, ?)",
+		chatID, userID, action, detail, time.Now().In(location).Format(timeLayout)); err != nil {
+		log.Printf("Failed to write audit log for %s: %v", action, err)
+	}
+}
+
+// newConfirmCode returns six random digits.
+func newConfirmCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// confirmWithCode runs a sensitive action, first asking the user to reply
+// with a random code when CONFIRM_CODES is on. label completes "To ..."
+// and names the action in the audit log.
+func confirmWithCode(chatID int64, userID int64, label string, run func()) {
+	if !CONFIRM_CODES {
+		logAudit(chatID, userID, "run", label)
+		run()
+		return
+	}
+	// The code prompt takes over the user's state, so don't clobber a flow
+	// in progress
+	if _, exists := userStates[stateKey{chatID, userID}]; exists {
+		sendMessage(chatID, "Please finish the current transaction first.")
+		return
+	}
+
+	code, err := newConfirmCode()
+	if err != nil {
+		sendMessage(chatID, "Failed to create a confirmation code.")
+		log.Printf("Confirmation code error: %v", err)
+		return
+	}
+	userStates[stateKey{chatID, userID}] = &TransactionState{
+		ChatID: chatID,
+		UserID: userID,
+		Step:   "CONFIRM_CODE",
+		Confirm: &confirmation{
+			code:    code,
+			label:   label,
+			expires: time.Now().Add(confirmCodeWindow),
+			run:     run,
+		},
+	}
+	logAudit(chatID, userID, "confirm_requested", label)
+	sendMessage(chatID, fmt.Sprintf("To %s, reply with the code %s within %d minutes, or /cancel.",
+		label, code, int(confirmCodeWindow.Minutes())))
+}
+
+func processConfirmCode(message *tgbotapi.Message, state *TransactionState) {
+	c := state.Confirm
+	if time.Now().After(c.expires) {
+		clearState(state)
+		logAudit(state.ChatID, state.UserID, "confirm_expired", c.label)
+		sendMessage(message.Chat.ID, "The confirmation code has expired. Run the command again.")
+		return
+	}
+	if strings.TrimSpace(message.Text) != c.code {
+		c.attempts++
+		if c.attempts >= confirmCodeAttempts {
+			clearState(state)
+			logAudit(state.ChatID, state.UserID, "confirm_failed", c.label)
+			sendMessage(message.Chat.ID, "Too many wrong codes. Nothing was changed.")
+			return
+		}
+		sendMessage(message.Chat.ID, "Wrong code. Try again, or /cancel.")
+		return
+	}
+
+	clearState(state)
+	logAudit(state.ChatID, state.UserID, "confirm_accepted", c.label)
+	c.run()
+}
@@ -0,0 +1,214 @@
+package main
+
+// Optional at-rest encryption of transaction descriptions.
+//
+// When DB_ENCRYPTION_KEY is set, descriptions are sealed with AES-256-GCM
+// (the key is the SHA-256 of DB_ENCRYPTION_KEY) and stored as
+// "enc:<base64>". Amounts, categories, dates and #tags stay in plaintext
+// so the reports can keep aggregating in SQL. The description words learned
+// for category suggestions are stored as keyed hashes instead.
+//
+// Migrating an existing plaintext database only requires setting the key:
+// on startup every description without the "enc:" prefix is encrypted in
+// place. Back up the .db file first, and keep the key safe — encrypted
+// descriptions cannot be recovered without it. The Python reports read the
+// table directly and will show the encrypted values.
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const encryptedPrefix = "enc:"
+
+// escapedPrefix is put in front of plaintext stored without a key that
+// would otherwise look encrypted or escaped, so reading it back never
+// fails.
+const escapedPrefix = "plain:"
+
+// fieldCipher and wordHashKey are nil when encryption is disabled.
+var (
+	fieldCipher cipher.AEAD
+	wordHashKey []byte
+)
+
+func initEncryption(key string) error {
+	words := sha256.Sum256([]byte("category_words:" + key))
+	wordHashKey = words[:]
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return err
+	}
+	fieldCipher, err = cipher.NewGCM(block)
+	return err
+}
+
+func encryptField(plaintext string) (string, error) {
+	if fieldCipher == nil || plaintext == "" {
+		if strings.HasPrefix(plaintext, encryptedPrefix) || strings.HasPrefix(plaintext, escapedPrefix) {
+			return escapedPrefix + plaintext, nil
+		}
+		return plaintext, nil
+	}
+	nonce := make([]byte, fieldCipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := fieldCipher.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptField returns plaintext values unchanged, apart from removing
+// the escape added by encryptField, so databases can hold a mix of old and
+// encrypted rows.
+func decryptField(value string) (string, error) {
+	if strings.HasPrefix(value, escapedPrefix) {
+		return strings.TrimPrefix(value, escapedPrefix), nil
+	}
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+	if fieldCipher == nil {
+		return "", errors.New("encrypted value but DB_ENCRYPTION_KEY is not set")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil {
+		return "", err
+	}
+	size := fieldCipher.NonceSize()
+	if len(sealed) < size {
+		return "", errors.New("encrypted value is too short")
+	}
+	plaintext, err := fieldCipher.Open(nil, sealed[:size], sealed[size:], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// encryptedTables hold a description column written through encryptField.
+var encryptedTables = []string{"transactions", "recurring", "quick_entries"}
+
+// encryptExistingDescriptions encrypts any plaintext descriptions left in conn.
+func encryptExistingDescriptions(conn *sql.DB) (int, error) {
+	total := 0
+	for _, table := range encryptedTables {
+		n, err := encryptTableDescriptions(conn, table)
+		if err != nil {
+			return total, fmt.Errorf("%s: %w", table, err)
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// encryptTableDescriptions goes by rowid, since quick_entries has no id.
+func encryptTableDescriptions(conn *sql.DB, table string) (int, error) {
+	rows, err := conn.Query("SELECT rowid, description FROM " + table + " WHERE description IS NOT NULL AND description != '' AND description NOT LIKE 'enc:%'")
+	if err != nil {
+		return 0, err
+	}
+	plain := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var description string
+		if err := rows.Scan(&id, &description); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		plain[id] = description
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for id, description := range plain {
+		// Drop the escape before sealing the text
+		description, err := decryptField(description)
+		if err != nil {
+			return 0, err
+		}
+		sealed, err := encryptField(description)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := conn.Exec("UPDATE "+table+" SET description = ? WHERE rowid = ?", sealed, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(plain), nil
+}
+
+// hashedWordPrefix marks a hashed word. descriptionWords never returns a
+// word starting with it, so the two forms can't collide.
+const hashedWordPrefix = "#"
+
+// storedWord is how a description word is kept in category_words: as is
+// without a key, otherwise an HMAC of it so the table doesn't give the
+// encrypted descriptions away.
+func storedWord(word string) string {
+	if wordHashKey == nil {
+		return word
+	}
+	mac := hmac.New(sha256.New, wordHashKey)
+	mac.Write([]byte(word))
+	return hashedWordPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// hashExistingCategoryWords replaces the plaintext words learned before the
+// key was set with their hashes, merging weights that meet on one hash.
+func hashExistingCategoryWords(conn *sql.DB) (int, error) {
+	tx, err := conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	type learned struct {
+		chatID   int64
+		word     string
+		category string
+		weight   float64
+	}
+	rows, err := tx.Query("SELECT chat_id, word, category, weight FROM category_words WHERE word NOT LIKE '" + hashedWordPrefix + "%'")
+	if err != nil {
+		return 0, err
+	}
+	var plain []learned
+	for rows.Next() {
+		var l learned
+		if err := rows.Scan(&l.chatID, &l.word, &l.category, &l.weight); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		plain = append(plain, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, l := range plain {
+		if _, err := tx.Exec("DELETE FROM category_words WHERE chat_id = ? AND word = ? AND category = ?", l.chatID, l.word, l.category); err != nil {
+			return 0, err
+		}
+		_, err := tx.Exec(`INSERT INTO category_words (chat_id, word, category, weight) VALUES (?, ?, ?, ?)
+			ON CONFLICT(chat_id, word, category) DO UPDATE SET weight = weight + excluded.weight`,
+			l.chatID, storedWord(l.word), l.category, l.weight)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(plain), tx.Commit()
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestFieldRoundTrip(t *testing.T) {
+	values := []string{"", "lunch", "enc:not encrypted", "plain:text", "plain:enc:both"}
+
+	check := func(mode string) {
+		for _, value := range values {
+			stored, err := encryptField(value)
+			if err != nil {
+				t.Fatalf("%s: encryptField(%q) returned error: %v", mode, value, err)
+			}
+			got, err := decryptField(stored)
+			if err != nil {
+				t.Errorf("%s: decryptField(%q) returned error: %v", mode, stored, err)
+				continue
+			}
+			if got != value {
+				t.Errorf("%s: round trip of %q gave %q", mode, value, got)
+			}
+		}
+	}
+
+	check("without a key")
+
+	defer func() { fieldCipher, wordHashKey = nil, nil }()
+	if err := initEncryption("test key"); err != nil {
+		t.Fatal(err)
+	}
+	check("with a key")
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// rateSource converts between currencies. The static table from
+// CURRENCY_RATES is the only implementation; an API-backed source can be
+// swapped in through exchangeRates.
+type rateSource interface {
+	// Rate returns how many units of to one unit of from is worth.
+	Rate(from, to string) (float64, error)
+}
+
+// staticRates holds the value of one unit of each currency in BASE_CURRENCY.
+type staticRates map[string]float64
+
+func (r staticRates) Rate(from, to string) (float64, error) {
+	fromRate, ok := r[from]
+	if !ok {
+		return 0, fmt.Errorf("no rate for %s", from)
+	}
+	toRate, ok := r[to]
+	if !ok {
+		return 0, fmt.Errorf("no rate for %s", to)
+	}
+	return fromRate / toRate, nil
+}
+
+// exchangeRates is the active rate source.
+var exchangeRates rateSource = staticRates{}
+
+// parseCurrencyRates reads CURRENCY_RATES, e.g. "USD=16000,EUR=17500",
+// where each value is one unit of that currency in BASE_CURRENCY.
+func parseCurrencyRates(value string) (staticRates, error) {
+	rates := staticRates{BASE_CURRENCY: 1}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		code, rate, ok := strings.Cut(pair, "=")
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if !ok || !isCurrencyCode(code) {
+			return nil, fmt.Errorf("invalid entry %q, expected CODE=rate", pair)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("invalid rate for %s", code)
+		}
+		rates[code] = v
+	}
+	return rates, nil
+}
+
+func isCurrencyCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, r := range code {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitCurrency separates a currency code written before or after an
+// amount, as in "25 USD" or "usd 25". The code is empty when text has none.
+func splitCurrency(text string) (string, string) {
+	fields := strings.Fields(text)
+	if len(fields) < 2 {
+		return text, ""
+	}
+	if last := fields[len(fields)-1]; isCurrencyCode(last) {
+		return strings.Join(fields[:len(fields)-1], " "), strings.ToUpper(last)
+	}
+	if isCurrencyCode(fields[0]) {
+		return strings.Join(fields[1:], " "), strings.ToUpper(fields[0])
+	}
+	return text, ""
+}
+
+// convertAmount converts amount between currencies, rounding to whole
+// units when WHOLE_NUMBER_AMOUNTS is enabled.
+func convertAmount(amount float64, from, to string) (float64, error) {
+	rate, err := exchangeRates.Rate(from, to)
+	if err != nil {
+		return 0, err
+	}
+	converted := amount * rate
+	if WHOLE_NUMBER_AMOUNTS {
+		converted = math.Round(converted)
+	}
+	return converted, nil
+}
+
+func handleConvert(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) < 2 || len(fields) > 3 {
+		sendMessage(chatID, fmt.Sprintf("Usage: /convert <amount> <from> [to], e.g. /convert 25 USD %s", BASE_CURRENCY))
+		return
+	}
+	amount, err := parseAmount(fields[0])
+	if err != nil || amount <= 0 {
+		sendMessage(chatID, "Invalid amount. Please enter a positive number.")
+		return
+	}
+	from, to := strings.ToUpper(fields[1]), BASE_CURRENCY
+	if len(fields) == 3 {
+		to = strings.ToUpper(fields[2])
+	}
+
+	converted, err := convertAmount(amount, from, to)
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("Can't convert: %v. Rates are set in CURRENCY_RATES.", err))
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("%s %s = %s %s", formatNumber(amount), from, formatNumber(converted), to))
+}
+
+// setCurrencySymbol shows or changes the currency_symbol setting, which
+// /set currency_symbol changes too.
+func setCurrencySymbol(chatID int64, userID int64, args string) {
+	def, _ := findSettingDef("currency_symbol")
+	symbol := strings.TrimSpace(args)
+	if symbol == "" {
+		sendMessage(chatID, fmt.Sprintf("Currency symbol: %s, e.g. %s\nChange it with /setcurrency <symbol>, or /setcurrency none.", def.current(userID), formatAmount(1500)))
+		return
+	}
+	if userID != ALLOWED_USER_ID {
+		sendMessage(chatID, "Only the bot owner can change the currency symbol.")
+		return
+	}
+	previous := def.current(userID)
+	if err := def.apply(symbol); err != nil {
+		sendMessage(chatID, fmt.Sprintf("Invalid currency symbol: %v", err))
+		return
+	}
+	if err := setSetting(globalSettingsUser, def.key, def.current(userID)); err != nil {
+		def.apply(previous)
+		sendMessage(chatID, "Failed to save setting.")
+		log.Printf("Database exec error: %v", err)
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("Amounts now look like %s.", formatAmount(1500)))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// defaultProfile is the name of the database at DB_PATH.
+const defaultProfile = "default"
+
+// profiles holds every open database by profile name.
+var profiles = make(map[string]*sql.DB)
+
+// openDatabase opens the SQLite file at path and brings its schema up to date.
+func openDatabase(path string) (*sql.DB, error) {
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := runMigrations(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if fieldCipher != nil {
+		n, err := encryptExistingDescriptions(conn)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("encrypting existing descriptions: %w", err)
+		}
+		if n > 0 {
+			log.Printf("Encrypted %d existing description(s) in %s", n, path)
+		}
+		n, err = hashExistingCategoryWords(conn)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("hashing learned category words: %w", err)
+		}
+		if n > 0 {
+			log.Printf("Hashed %d learned category word(s) in %s", n, path)
+		}
+	}
+
+	return conn, nil
+}
+
+// addColumnIfMissing adds a column to an existing table, reporting whether
+// it had to be added.
+func addColumnIfMissing(conn *sql.DB, table, column, definition string) (bool, error) {
+	rows, err := conn.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return false, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+
+	_, err = conn.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err == nil, err
+}
+
+// openProfiles opens the databases listed in PROFILES as name=path pairs
+// and switches to the profile the allowed user last selected.
+func openProfiles(value string) error {
+	profiles[defaultProfile] = mainDB
+	profilePaths[defaultProfile] = DB_PATH
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, path, ok := strings.Cut(pair, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || name == "" || strings.TrimSpace(path) == "" {
+			return fmt.Errorf("invalid PROFILES entry %q, expected name=path", pair)
+		}
+		if _, exists := profiles[name]; exists {
+			return fmt.Errorf("duplicate profile %q", name)
+		}
+		conn, err := openDatabase(strings.TrimSpace(path))
+		if err != nil {
+			return fmt.Errorf("profile %s: %w", name, err)
+		}
+		profiles[name] = conn
+		profilePaths[name] = strings.TrimSpace(path)
+	}
+
+	active := getSetting(ALLOWED_USER_ID, "profile", defaultProfile)
+	if conn, ok := profiles[active]; ok {
+		db = conn
+		log.Printf("Using profile %s", active)
+	}
+	return nil
+}
+
+func profileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func handleProfile(chatID int64, userID int64, args string) {
+	// The active profile is the owner's choice, whoever is asking
+	current := getSetting(ALLOWED_USER_ID, "profile", defaultProfile)
+	name := strings.ToLower(strings.TrimSpace(args))
+	if name == "" {
+		sendMessage(chatID, fmt.Sprintf("Active profile: %s\nAvailable: %s\n\nSwitch with /profile <name>.", current, strings.Join(profileNames(), ", ")))
+		return
+	}
+	// Switching swaps the database for every chat, not just this one
+	if userID != ALLOWED_USER_ID {
+		sendMessage(chatID, "Only the bot owner can switch profiles.")
+		return
+	}
+
+	conn, ok := profiles[name]
+	if !ok {
+		sendMessage(chatID, fmt.Sprintf("Unknown profile: %s. Available: %s", name, strings.Join(profileNames(), ", ")))
+		return
+	}
+	// The active database is shared, so wait until nobody is mid-flow
+	if len(userStates) > 0 {
+		sendMessage(chatID, "Please finish any transaction in progress before switching profiles.")
+		return
+	}
+
+	if err := setSetting(ALLOWED_USER_ID, "profile", name); err != nil {
+		sendMessage(chatID, "Failed to save the profile selection.")
+		log.Printf("Database exec error: %v", err)
+		return
+	}
+	db = conn
+	log.Printf("Switched to profile %s", name)
+	sendMessage(chatID, fmt.Sprintf("Switched to profile %s.", name))
+}
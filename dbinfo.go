@@ -0,0 +1,80 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+)
+
+// formatFileSize renders a byte count with a binary unit, e.g. "1.5 MiB".
+func formatFileSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// showDBInfo reports the active database's file size and row counts, to
+// help decide when to archive or back up. It only reads.
+func showDBInfo(chatID int64) {
+	path := activeProfilePath()
+	text := "Database: " + path + "\n"
+	if info, err := os.Stat(path); err != nil {
+		log.Printf("Stat error for %s: %v", path, err)
+		text += "Size: unknown\n"
+	} else {
+		text += "Size: " + formatFileSize(info.Size()) + "\n"
+	}
+	// Recent writes may still sit in the write-ahead log
+	if info, err := os.Stat(path + "-wal"); err == nil && info.Size() > 0 {
+		text += "Write-ahead log: " + formatFileSize(info.Size()) + "\n"
+	}
+
+	var total, trashed int
+	var first, last sql.NullString
+	err := db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(deleted_at IS NOT NULL), 0),
+		MIN(CASE WHEN deleted_at IS NULL THEN strftime('%Y-%m-%d', created_at) END),
+		MAX(CASE WHEN deleted_at IS NULL THEN strftime('%Y-%m-%d', created_at) END)
+		FROM transactions`).Scan(&total, &trashed, &first, &last)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving database info.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	text += fmt.Sprintf("\nTransactions: %d", total-trashed)
+	if trashed > 0 {
+		text += fmt.Sprintf(" (+%d in the trash)", trashed)
+	}
+	text += "\n"
+	if first.Valid {
+		text += fmt.Sprintf("Earliest: %s\nLatest: %s\n", first.String, last.String)
+	}
+
+	rows, err := db.Query("SELECT type, COUNT(*) FROM transactions WHERE deleted_at IS NULL GROUP BY type ORDER BY type")
+	if err != nil {
+		sendMessage(chatID, "Error retrieving database info.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var transactionType string
+		var count int
+		if err := rows.Scan(&transactionType, &count); err != nil {
+			log.Printf("Row scan error: %v", err)
+			continue
+		}
+		text += fmt.Sprintf("  %s: %d\n", transactionType, count)
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("Rows error: %v", err)
+	}
+	sendMessage(chatID, text)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// duplicatePreviewGroups is how many groups /merge_duplicates lists before
+// asking for confirmation.
+const duplicatePreviewGroups = 10
+
+// findDuplicates groups the chat's transactions that share type, category,
+// amount, description and day, oldest id first, keeping only groups with
+// more than one row. Descriptions are compared after decryption since the
+// stored ciphertext differs even for equal text.
+func findDuplicates(chatID int64) ([][]Transaction, error) {
+	transactions, err := queryTransactions("SELECT "+transactionColumns+" FROM transactions WHERE chat_id = ? AND deleted_at IS NULL ORDER BY id", chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]int)
+	var groups [][]Transaction
+	for _, t := range transactions {
+		key := strings.Join([]string{t.Type, t.Category, fmt.Sprintf("%.6f", t.Amount), t.Description, t.CreatedAt[:10]}, "\x00")
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], t)
+	}
+
+	duplicates := groups[:0]
+	for _, group := range groups {
+		if len(group) > 1 {
+			duplicates = append(duplicates, group)
+		}
+	}
+	return duplicates, nil
+}
+
+// confirmMergeDuplicates reports what /merge_duplicates would remove and
+// asks before doing it.
+func confirmMergeDuplicates(chatID int64) {
+	groups, err := findDuplicates(chatID)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	if len(groups) == 0 {
+		sendMessage(chatID, "No duplicate transactions found.")
+		return
+	}
+
+	extra := 0
+	text := ""
+	for i, group := range groups {
+		extra += len(group) - 1
+		if i < duplicatePreviewGroups {
+			text += fmt.Sprintf("%d× ", len(group)) + formatTransactionList(group[:1])
+		}
+	}
+	if len(groups) > duplicatePreviewGroups {
+		text += fmt.Sprintf("…and %d more group(s)\n", len(groups)-duplicatePreviewGroups)
+	}
+	text = fmt.Sprintf("Found %d group(s) of duplicates. Keeping the oldest of each would move %d transaction(s) to the trash:\n\n", len(groups), extra) + text
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Merge", "dupes:yes"),
+		tgbotapi.NewInlineKeyboardButtonData("Cancel", "dupes:no"),
+	))
+	sendMessageWithKeyboard(chatID, text, keyboard)
+}
+
+// processMergeDuplicates looks the duplicates up again, since rows may have
+// changed since the preview, and trashes all but the oldest of each group.
+func processMergeDuplicates(callback *tgbotapi.CallbackQuery) {
+	chatID, messageID := callback.Message.Chat.ID, callback.Message.MessageID
+	if callback.Data != "dupes:yes" {
+		editMessage(chatID, messageID, "Merge cancelled.")
+		answerCallback(callback, "")
+		return
+	}
+
+	groups, err := findDuplicates(chatID)
+	if err != nil {
+		editMessage(chatID, messageID, "Error retrieving transactions.")
+		answerCallback(callback, "")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		editMessage(chatID, messageID, "Failed to merge duplicates.")
+		answerCallback(callback, "")
+		log.Printf("Database begin error: %v", err)
+		return
+	}
+	defer tx.Rollback()
+	now := time.Now().In(location).Format(timeLayout)
+	var removed []int64
+	for _, group := range groups {
+		for _, t := range group[1:] {
+			if _, err := tx.Exec("UPDATE transactions SET deleted_at = ? WHERE id = ? AND chat_id = ? AND deleted_at IS NULL", now, t.ID, chatID); err != nil {
+				editMessage(chatID, messageID, "Failed to merge duplicates.")
+				answerCallback(callback, "")
+				log.Printf("Database exec error: %v", err)
+				return
+			}
+			removed = append(removed, t.ID)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		editMessage(chatID, messageID, "Failed to merge duplicates.")
+		answerCallback(callback, "")
+		log.Printf("Database commit error: %v", err)
+		return
+	}
+
+	for _, id := range removed {
+		if err := syncRoundUp(chatID, id); err != nil {
+			log.Printf("Failed to remove round-up of transaction %d: %v", id, err)
+		}
+	}
+	log.Printf("Merged duplicates in chat %d: %d transaction(s) moved to the trash", chatID, len(removed))
+	editMessage(chatID, messageID, fmt.Sprintf("Moved %d duplicate transaction(s) to the trash. See /trash to restore any.", len(removed)))
+	answerCallback(callback, "Merged")
+	refreshPin(chatID)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// transactionsCSV renders transactions with a header row. Amounts are
+// written unformatted so spreadsheets can sum them.
+func transactionsCSV(transactions []Transaction) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write([]string{"id", "created_at", "type", "category", "amount", "description", "reference"}); err != nil {
+		return nil, err
+	}
+	for _, t := range transactions {
+		record := []string{
+			strconv.FormatInt(t.ID, 10),
+			t.CreatedAt,
+			t.Type,
+			t.Category,
+			strconv.FormatFloat(t.Amount, 'f', -1, 64),
+			t.Description,
+			t.Reference,
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// sendTransactionsCSV sends the chat's transactions created in [start, end)
+// as a CSV document.
+func sendTransactionsCSV(chatID int64, start, end time.Time, label string) {
+	transactions, err := queryTransactions(
+		"SELECT "+transactionColumns+" FROM transactions WHERE chat_id = ? AND deleted_at IS NULL AND created_at >= ? AND created_at < ? ORDER BY created_at",
+		chatID, start.Format(timeLayout), end.Format(timeLayout),
+	)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	if len(transactions) == 0 {
+		sendMessage(chatID, fmt.Sprintf("No transactions for %s.", label))
+		return
+	}
+
+	name := fmt.Sprintf("transactions_%s_%s.csv", start.Format("20060102"), end.AddDate(0, 0, -1).Format("20060102"))
+	sendCSV(chatID, transactions, name, fmt.Sprintf("%d transaction(s), %s", len(transactions), label))
+}
+
+// sendCSV sends transactions as a CSV document named name.
+func sendCSV(chatID int64, transactions []Transaction, name, caption string) {
+	data, err := transactionsCSV(transactions)
+	if err != nil {
+		sendMessage(chatID, "Failed to build the CSV file.")
+		log.Printf("CSV error: %v", err)
+		return
+	}
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: name, Bytes: data})
+	doc.Caption = caption
+	if _, err := bot.Send(doc); err != nil {
+		log.Printf("Error sending document: %v", err)
+	}
+}
+
+func exportTransactions(chatID int64, args string) {
+	start, end, label, err := parsePeriod(chatID, args)
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("%v\nUsage: /export [month|year|all|YYYY-MM]", err))
+		return
+	}
+	sendTransactionsCSV(chatID, start, end, label)
+}
+
+// exportCategory sends one category's transactions as a CSV, for example
+// to claim reimbursement, optionally limited to a month.
+func exportCategory(chatID int64, args string) {
+	cmd := newCommandArgs(args, "/export_category <name> [YYYY-MM]")
+	category := cmd.category()
+	where := "chat_id = ? AND deleted_at IS NULL AND category = ?"
+	queryArgs := []interface{}{chatID, category}
+	label, suffix := "all time", "all"
+	if cmd.has() {
+		month := cmd.month()
+		where += " AND created_at >= ? AND created_at < ?"
+		queryArgs = append(queryArgs, month.Format(timeLayout), month.AddDate(0, 1, 0).Format(timeLayout))
+		label, suffix = month.Format("January 2006"), month.Format("200601")
+	}
+	if !cmd.ok(chatID) {
+		return
+	}
+
+	transactions, err := queryTransactions("SELECT "+transactionColumns+" FROM transactions WHERE "+where+" ORDER BY created_at", queryArgs...)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	if len(transactions) == 0 {
+		sendMessage(chatID, fmt.Sprintf("No %s transactions for %s.", category, label))
+		return
+	}
+
+	total := 0.0
+	for _, t := range transactions {
+		total += t.Amount
+	}
+	name := fmt.Sprintf("%s_%s.csv", strings.ToLower(strings.ReplaceAll(category, " ", "_")), suffix)
+	sendCSV(chatID, transactions, name, fmt.Sprintf("%s, %s: %d transaction(s), total %s", category, label, len(transactions), formatAmount(total)))
+}
+
+// summaryExport sends a month's summary followed by its CSV, for the
+// monthly bookkeeping routine.
+func summaryExport(chatID int64, userID int64, args string) {
+	start, end, label, err := parsePeriod(chatID, args)
+	if err == nil && !end.Equal(start.AddDate(0, 1, 0)) {
+		err = fmt.Errorf("summaries cover a single month")
+	}
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("%v\nUsage: /summary_export [month|YYYY-MM]", err))
+		return
+	}
+	showMonthSummary(chatID, start, userSummaryOptions(userID))
+	sendTransactionsCSV(chatID, start, end, label)
+}
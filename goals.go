@@ -0,0 +1,161 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+)
+
+type goal struct {
+	Name     string
+	Target   float64
+	Deadline time.Time
+}
+
+func handleGoal(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		fields = []string{"list"}
+	}
+
+	switch strings.ToLower(fields[0]) {
+	case "set":
+		setGoal(chatID, fields[1:])
+	case "show", "list":
+		showGoals(chatID, strings.Join(fields[1:], " "))
+	case "delete":
+		deleteGoal(chatID, strings.Join(fields[1:], " "))
+	default:
+		sendMessage(chatID, "Usage: /goal set <name> <target> <YYYY-MM-DD>, /goal show [name] or /goal delete <name>")
+	}
+}
+
+func setGoal(chatID int64, fields []string) {
+	if len(fields) != 3 {
+		sendMessage(chatID, "Usage: /goal set <name> <target> <YYYY-MM-DD>")
+		return
+	}
+	name := strings.ToLower(fields[0])
+	target, err := parseAmount(fields[1])
+	if err != nil || target <= 0 || target > maxAmount {
+		sendMessage(chatID, "Invalid target. Please enter a positive number.")
+		return
+	}
+	deadline, err := time.ParseInLocation("2006-01-02", fields[2], location)
+	if err != nil {
+		sendMessage(chatID, "Invalid deadline. Use YYYY-MM-DD.")
+		return
+	}
+	if !deadline.After(time.Now().In(location)) {
+		sendMessage(chatID, "The deadline must be in the future.")
+		return
+	}
+
+	_, err = db.Exec(`INSERT INTO goals (chat_id, name, target, deadline) VALUES (?, ?, ?, ?)
+		ON CONFLICT(chat_id, name) DO UPDATE SET target = excluded.target, deadline = excluded.deadline`,
+		chatID, name, target, deadline.Format("2006-01-02"))
+	if err != nil {
+		sendMessage(chatID, "Failed to save goal.")
+		log.Printf("Database exec error: %v", err)
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("Goal %s set: %s by %s.", name, formatAmount(target), deadline.Format("2 Jan 2006")))
+}
+
+func queryGoals(chatID int64, name string) ([]goal, error) {
+	rows, err := db.Query("SELECT name, target, deadline FROM goals WHERE chat_id = ? AND (? = '' OR name = ?) ORDER BY deadline",
+		chatID, name, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var goals []goal
+	for rows.Next() {
+		var g goal
+		var deadline string
+		if err := rows.Scan(&g.Name, &g.Target, &deadline); err != nil {
+			return nil, err
+		}
+		if g.Deadline, err = time.ParseInLocation("2006-01-02", deadline, location); err != nil {
+			return nil, err
+		}
+		goals = append(goals, g)
+	}
+	return goals, rows.Err()
+}
+
+// chatSavings is the chat's lifetime income minus expense, which every
+// goal measures its progress against, counting cleared rows only. Round-up
+// savings rows are still saved money, so they don't count against it.
+func chatSavings(chatID int64) (float64, error) {
+	var savings sql.NullFloat64
+	err := db.QueryRow("SELECT SUM(CASE type WHEN 'income' THEN amount WHEN 'expense' THEN -amount ELSE 0 END) FROM transactions WHERE chat_id = ? AND deleted_at IS NULL AND status = 'cleared'", chatID).Scan(&savings)
+	return savings.Float64, err
+}
+
+func showGoals(chatID int64, name string) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	goals, err := queryGoals(chatID, name)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving goals.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	if len(goals) == 0 {
+		if name != "" {
+			sendMessage(chatID, fmt.Sprintf("No goal named %s.", name))
+		} else {
+			sendMessage(chatID, "No goals yet. Add one with /goal set <name> <target> <YYYY-MM-DD>.")
+		}
+		return
+	}
+
+	savings, err := chatSavings(chatID)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+
+	now := time.Now().In(location)
+	text := fmt.Sprintf("Savings so far: %s\n", formatAmount(savings))
+	for _, g := range goals {
+		progress := math.Max(0, math.Min(savings/g.Target, 1)) * 100
+		text += fmt.Sprintf("\n%s: %s of %s (%.0f%%)\n", g.Name, formatAmount(math.Max(savings, 0)), formatAmount(g.Target), progress)
+
+		days := int(math.Ceil(g.Deadline.Sub(now).Hours() / 24))
+		switch {
+		case savings >= g.Target:
+			text += "Reached! 🎉\n"
+		case days <= 0:
+			text += fmt.Sprintf("Deadline %s has passed.\n", g.Deadline.Format("2 Jan 2006"))
+		default:
+			months := math.Max(float64(days)/30.44, 1)
+			text += fmt.Sprintf("%d day(s) left, save %s per month to make it.\n", days, formatAmount((g.Target-savings)/months))
+		}
+	}
+	sendMessage(chatID, text)
+}
+
+func deleteGoal(chatID int64, name string) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		sendMessage(chatID, "Usage: /goal delete <name>")
+		return
+	}
+	result, err := db.Exec("DELETE FROM goals WHERE chat_id = ? AND name = ?", chatID, name)
+	if err != nil {
+		sendMessage(chatID, "Failed to delete goal.")
+		log.Printf("Database exec error: %v", err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		sendMessage(chatID, fmt.Sprintf("No goal named %s.", name))
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("Goal %s deleted.", name))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	startedAt      = time.Now()
+	lastUpdateMu   sync.Mutex
+	lastUpdateTime time.Time
+)
+
+func markUpdateReceived() {
+	lastUpdateMu.Lock()
+	lastUpdateTime = time.Now()
+	lastUpdateMu.Unlock()
+}
+
+// startHealthServer serves GET /health on port for uptime monitors, and
+// POST /ingest for external sources when INGEST_SECRET is set.
+func startHealthServer(port string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		lastUpdateMu.Lock()
+		lastUpdate := lastUpdateTime
+		lastUpdateMu.Unlock()
+
+		// mainDB never changes after startup, unlike db, which /profile
+		// swaps on the update loop
+		dbOK := true
+		var one int
+		if err := mainDB.QueryRow("SELECT 1").Scan(&one); err != nil {
+			log.Printf("Health check database error: %v", err)
+			dbOK = false
+		}
+
+		body := map[string]interface{}{
+			"status":         "ok",
+			"uptime_seconds": int64(time.Since(startedAt).Seconds()),
+			"database":       dbOK,
+			"last_update":    nil,
+		}
+		if !lastUpdate.IsZero() {
+			body["last_update"] = lastUpdate.UTC().Format(time.RFC3339)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !dbOK {
+			body["status"] = "degraded"
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(body)
+	})
+
+	if INGEST_SECRET != "" {
+		mux.HandleFunc("/ingest", handleIngest)
+		log.Printf("Ingest endpoint listening on :%s/ingest", port)
+	}
+
+	go func() {
+		log.Printf("Health endpoint listening on :%s/health", port)
+		if err := http.ListenAndServe(":"+port, mux); err != nil {
+			log.Printf("Health server error: %v", err)
+		}
+	}()
+}
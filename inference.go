@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Weights applied to description words when learning categories. A
+// correction counts for more than a plain save so a wrong suggestion is
+// unlearned quickly.
+const (
+	learnWeight      = 1.0
+	correctionWeight = 2.0
+	penaltyWeight    = -1.0
+)
+
+// descriptionWords splits a description into the lowercase words used for
+// category inference, dropping #tags, numbers and very short words. Every
+// word starts and ends with a letter.
+func descriptionWords(description string) []string {
+	var words []string
+	seen := make(map[string]bool)
+	for _, field := range strings.Fields(strings.ToLower(description)) {
+		if strings.HasPrefix(field, "#") {
+			continue
+		}
+		word := strings.TrimFunc(field, func(r rune) bool { return !unicode.IsLetter(r) })
+		if len([]rune(word)) < 3 || seen[word] {
+			continue
+		}
+		seen[word] = true
+		words = append(words, word)
+	}
+	return words
+}
+
+// learnCategory adds weight to the link between each word of description
+// and category for the chat. Words are hashed when encryption is on.
+func learnCategory(chatID int64, description string, category string, weight float64) {
+	for _, word := range descriptionWords(description) {
+		_, err := db.Exec(`INSERT INTO category_words (chat_id, word, category, weight) VALUES (?, ?, ?, ?)
+			ON CONFLICT(chat_id, word, category) DO UPDATE SET weight = weight + excluded.weight`,
+			chatID, storedWord(word), category, weight)
+		if err != nil {
+			log.Printf("Failed to learn category %s: %v", category, err)
+			return
+		}
+	}
+}
+
+// inferCategory guesses the category of description from the chat's
+// learned words. Only categories that are still configured are suggested.
+func inferCategory(chatID int64, description string) (string, bool) {
+	words := descriptionWords(description)
+	if len(words) == 0 {
+		return "", false
+	}
+
+	args := []interface{}{chatID}
+	for _, word := range words {
+		args = append(args, storedWord(word))
+	}
+	rows, err := db.Query(`SELECT category, SUM(weight) AS score FROM category_words
+		WHERE chat_id = ? AND word IN (?`+strings.Repeat(", ?", len(words)-1)+`)
+		GROUP BY category HAVING score > 0 ORDER BY score DESC`, args...)
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+		return "", false
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var score float64
+		if err := rows.Scan(&name, &score); err != nil {
+			log.Printf("Row scan error: %v", err)
+			return "", false
+		}
+		if category, ok := findCategory(name); ok {
+			return category, true
+		}
+	}
+	return "", false
+}
+
+// promptInferredCategory offers the category learned for the description
+// typed during /add when it differs from the one picked, and reports
+// whether it asked. A matching suggestion is kept without asking.
+func promptInferredCategory(chatID int64, state *TransactionState) bool {
+	suggested, ok := inferCategory(chatID, state.Description)
+	if !ok {
+		return false
+	}
+	state.Suggested = suggested
+	if suggested == state.Category {
+		return false
+	}
+
+	state.Step = "CONFIRM_CATEGORY"
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("✓ "+suggested, "infer:use"),
+		tgbotapi.NewInlineKeyboardButtonData("Keep "+state.Category, "infer:keep"),
+	))
+	sendMessageWithKeyboard(chatID, fmt.Sprintf("Entries like this are usually %s. Use it instead of %s?", suggested, state.Category), keyboard)
+	return true
+}
+
+// processInferredCategory applies the answer to promptInferredCategory and
+// carries on with the /add flow. saveTransaction records the outcome.
+func processInferredCategory(callback *tgbotapi.CallbackQuery, state *TransactionState) {
+	chatID := callback.Message.Chat.ID
+	if callback.Data == "infer:use" {
+		state.Category = state.Suggested
+	}
+	editMessage(chatID, callback.Message.MessageID, fmt.Sprintf("Category: %s.", state.Category))
+	answerCallback(callback, "")
+	finishDescription(chatID, state)
+}
+
+// recordCategoryChoice learns from the category picked for description.
+// When a suggestion was shown, the outcome is logged for /inference and a
+// rejected suggestion loses weight.
+func recordCategoryChoice(chatID int64, description string, suggested string, chosen string) {
+	if suggested == "" {
+		learnCategory(chatID, description, chosen, learnWeight)
+		return
+	}
+
+	if _, err := db.Exec("INSERT INTO category_feedback (chat_id, suggested, chosen, created_at) VALUES (?, ?, ?, ?)",
+		chatID, suggested, chosen, time.Now().In(location).Format(timeLayout)); err != nil {
+		log.Printf("Failed to record category feedback: %v", err)
+	}
+	if suggested == chosen {
+		learnCategory(chatID, description, chosen, learnWeight)
+		return
+	}
+	learnCategory(chatID, description, chosen, correctionWeight)
+	learnCategory(chatID, description, suggested, penaltyWeight)
+}
+
+// showInferenceAccuracy reports how often suggested categories were kept.
+func showInferenceAccuracy(chatID int64) {
+	rows, err := db.Query(`SELECT suggested, COUNT(*), SUM(suggested = chosen) FROM category_feedback
+		WHERE chat_id = ? GROUP BY suggested ORDER BY COUNT(*) DESC`, chatID)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving suggestion feedback.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	var lines strings.Builder
+	total, accepted := 0, 0
+	for rows.Next() {
+		var category string
+		var count, kept int
+		if err := rows.Scan(&category, &count, &kept); err != nil {
+			log.Printf("Row scan error: %v", err)
+			continue
+		}
+		total += count
+		accepted += kept
+		lines.WriteString(fmt.Sprintf("%s: %d of %d kept\n", category, kept, count))
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Rows error: %v", err)
+	}
+	if total == 0 {
+		sendMessage(chatID, "No category suggestions have been answered yet.")
+		return
+	}
+
+	sendMessage(chatID, fmt.Sprintf("Category suggestions: %d of %d kept (%.0f%%)\n\n%s",
+		accepted, total, float64(accepted)/float64(total)*100, lines.String()))
+}
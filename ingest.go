@@ -0,0 +1,200 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxIngestBody caps the size of a POST /ingest request.
+const maxIngestBody = 64 << 10
+
+// ingestRequest is the JSON body accepted by POST /ingest, e.g. from a
+// service that parses bank SMS. ChatID defaults to the owner's chat and
+// Date, in YYYY-MM-DD, to now.
+type ingestRequest struct {
+	ChatID      int64   `json:"chat_id"`
+	Type        string  `json:"type"`
+	Category    string  `json:"category"`
+	Amount      float64 `json:"amount"`
+	Description string  `json:"description"`
+	Date        string  `json:"date"`
+	Reference   string  `json:"reference"`
+	Source      string  `json:"source"`
+}
+
+// validate normalizes the request into a transaction state, reporting
+// the first field that is wrong. It reads shared state, so it must run on
+// the update loop.
+func (req ingestRequest) validate() (*TransactionState, time.Time, error) {
+	chatID := req.ChatID
+	if chatID == 0 {
+		chatID = ALLOWED_USER_ID
+	}
+	if !allowedChats[chatID] {
+		return nil, time.Time{}, fmt.Errorf("chat %d is not allowed", chatID)
+	}
+	transactionType := strings.ToLower(strings.TrimSpace(req.Type))
+	if transactionType != "income" && transactionType != "expense" {
+		return nil, time.Time{}, errors.New("type must be income or expense")
+	}
+	category, ok := findCategory(strings.TrimSpace(req.Category))
+	if !ok {
+		return nil, time.Time{}, fmt.Errorf("unknown category %q", req.Category)
+	}
+	// The amount goes through the same checks as a typed one, such as
+	// WHOLE_NUMBER_AMOUNTS
+	amount, err := parseAmount(strconv.FormatFloat(req.Amount, 'f', -1, 64))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid amount: %v", err)
+	}
+	if amount <= 0 || amount > maxAmount {
+		return nil, time.Time{}, errors.New("amount must be a positive number")
+	}
+	if amount = roundEntryAmount(amount); amount <= 0 {
+		return nil, time.Time{}, errors.New("amount must be a positive number")
+	}
+	description := strings.TrimSpace(req.Description)
+	if len([]rune(description)) > 100 {
+		return nil, time.Time{}, errors.New("description is limited to 100 characters")
+	}
+	if len([]rune(req.Reference)) > 50 {
+		return nil, time.Time{}, errors.New("reference is limited to 50 characters")
+	}
+	// Dated entries keep the current time of day, like a typed @YYYY-MM-DD
+	now := time.Now().In(location)
+	createdAt := now
+	if req.Date != "" {
+		day, err := time.ParseInLocation("2006-01-02", req.Date, location)
+		if err != nil {
+			return nil, time.Time{}, errors.New("date must be YYYY-MM-DD")
+		}
+		createdAt = time.Date(day.Year(), day.Month(), day.Day(), now.Hour(), now.Minute(), now.Second(), 0, location)
+		if createdAt.After(now) {
+			return nil, time.Time{}, fmt.Errorf("%s is in the future", req.Date)
+		}
+	}
+
+	description, tags := extractTags(description)
+	return &TransactionState{
+		ChatID:          chatID,
+		TransactionType: transactionType,
+		Category:        category,
+		Amount:          amount,
+		Description:     description,
+		Tags:            tags,
+		Reference:       strings.TrimSpace(req.Reference),
+	}, createdAt, nil
+}
+
+// ingestResult is the HTTP response for one ingested request.
+type ingestResult struct {
+	status int
+	body   interface{}
+}
+
+// ingestJob carries a decoded request from the HTTP server to the update
+// loop, which owns db, categories and the other shared state, and the
+// response back.
+type ingestJob struct {
+	req   ingestRequest
+	reply chan ingestResult
+}
+
+// ingestJobs is read by the update loop in main.
+var ingestJobs = make(chan ingestJob)
+
+// handleIngest accepts a transaction posted by an external source. Requests
+// must carry INGEST_SECRET as a bearer token. The HTTP goroutine only
+// decodes the body; processIngest does the rest on the update loop.
+func handleIngest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeIngestError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(INGEST_SECRET)) != 1 {
+		log.Printf("Rejected ingest request from %s: bad secret", r.RemoteAddr)
+		writeIngestError(w, http.StatusUnauthorized, "invalid secret")
+		return
+	}
+
+	var req ingestRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxIngestBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeIngestError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+
+	// The reply channel is buffered so the update loop never waits on a
+	// client that went away
+	job := ingestJob{req: req, reply: make(chan ingestResult, 1)}
+	select {
+	case ingestJobs <- job:
+	case <-r.Context().Done():
+		return
+	}
+	select {
+	case result := <-job.reply:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(result.status)
+		json.NewEncoder(w).Encode(result.body)
+	case <-r.Context().Done():
+	}
+}
+
+// processIngest validates and logs an ingested transaction. It runs on the
+// update loop. A reference that was already logged in the chat is rejected,
+// so a resent SMS isn't counted twice.
+func processIngest(req ingestRequest) ingestResult {
+	state, createdAt, err := req.validate()
+	if err != nil {
+		return ingestError(http.StatusBadRequest, err.Error())
+	}
+
+	if state.Reference != "" {
+		var exists bool
+		if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM transactions WHERE chat_id = ? AND deleted_at IS NULL AND reference = ?)",
+			state.ChatID, state.Reference).Scan(&exists); err != nil {
+			log.Printf("Database query error: %v", err)
+			return ingestError(http.StatusInternalServerError, "database error")
+		}
+		if exists {
+			return ingestError(http.StatusConflict, "reference already logged")
+		}
+	}
+
+	id, _, err := storeTransaction(state.ChatID, state, createdAt)
+	if err != nil {
+		log.Printf("Failed to save transaction: %v", err)
+		return ingestError(http.StatusInternalServerError, "failed to save transaction")
+	}
+
+	source := strings.TrimSpace(req.Source)
+	if source == "" {
+		source = "webhook"
+	}
+	log.Printf("Ingested transaction #%d for chat %d from %s", id, state.ChatID, source)
+	sendScheduledMessage(state.ChatID, fmt.Sprintf("Auto-logged: %s %s %s from %s.",
+		state.TransactionType, state.Category, formatAmount(state.Amount), source))
+	refreshPin(state.ChatID)
+	return ingestResult{http.StatusCreated, map[string]interface{}{"id": id}}
+}
+
+func ingestError(status int, message string) ingestResult {
+	return ingestResult{status, map[string]string{"error": message}}
+}
+
+func writeIngestError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// finishDescription moves on to the optional payment method and location
+// steps, saving right away when the user turned neither on.
+func finishDescription(chatID int64, state *TransactionState) {
+	if state.PaymentMethod == "" && len(paymentMethods) > 0 && getSetting(state.UserID, "ask_payment_method", "false") == "true" {
+		promptPaymentMethod(chatID, state)
+		return
+	}
+	promptLocation(chatID, state)
+}
+
+// promptLocation saves the transaction, first asking for a location when
+// the user turned on ask_location.
+func promptLocation(chatID int64, state *TransactionState) {
+	if getSetting(state.UserID, "ask_location", "false") != "true" {
+		saveTransaction(chatID, state)
+		return
+	}
+
+	state.Step = "ENTER_LOCATION"
+	msg := tgbotapi.NewMessage(chatID, "Send the location of this transaction, or /skip.")
+	keyboard := tgbotapi.NewOneTimeReplyKeyboard(
+		tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButtonLocation("📍 Send location")),
+		tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton("/skip")),
+	)
+	keyboard.ResizeKeyboard = true
+	msg.ReplyMarkup = keyboard
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("Error sending message with keyboard: %v", err)
+	}
+}
+
+func processLocation(message *tgbotapi.Message, state *TransactionState) {
+	if message.Location == nil {
+		sendMessage(message.Chat.ID, "Please send a location with the 📎 menu, or /skip to save without one.")
+		return
+	}
+	state.Location = message.Location
+	saveTransaction(message.Chat.ID, state)
+}
+
+// mapLink points to a coordinate on a web map.
+func mapLink(latitude, longitude float64) string {
+	return fmt.Sprintf("https://maps.google.com/?q=%.6f,%.6f", latitude, longitude)
+}
+
+// showTransaction displays every detail of one transaction.
+func showTransaction(chatID int64, args string) {
+	cmd := newCommandArgs(args, "/show <id>")
+	id := cmd.id()
+	if !cmd.ok(chatID) {
+		return
+	}
+
+	t, err := getTransaction(chatID, id)
+	if err == sql.ErrNoRows {
+		sendMessage(chatID, fmt.Sprintf("Transaction #%d not found.", id))
+		return
+	}
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transaction.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	var status, paymentMethod string
+	var latitude, longitude sql.NullFloat64
+	if err := db.QueryRow("SELECT status, COALESCE(payment_method, ''), latitude, longitude FROM transactions WHERE id = ?", id).Scan(&status, &paymentMethod, &latitude, &longitude); err != nil {
+		sendMessage(chatID, "Error retrieving transaction.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+
+	text := fmt.Sprintf("Transaction #%d\n\nDate: %s\nType: %s\nCategory: %s\nAmount: %s",
+		t.ID, t.CreatedAt, t.Type, t.Category, formatAmount(t.Amount))
+	if t.Description != "" {
+		text += "\nDescription: " + t.Description
+	}
+	if t.Reference != "" {
+		text += "\nReference: " + t.Reference
+	}
+	if paymentMethod != "" {
+		text += "\nPaid by: " + paymentMethod
+	}
+	if status != "cleared" {
+		text += "\nStatus: " + status
+	}
+	if latitude.Valid && longitude.Valid {
+		text += "\nLocation: " + mapLink(latitude.Float64, longitude.Float64)
+	}
+	sendMessage(chatID, text)
+}
@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 	"os/exec"
 
@@ -15,24 +18,85 @@ import (
 )
 
 var (
-	API_TOKEN       string
-	ALLOWED_USER_ID int64
-	DB_PATH         string
-	categories      = []string
-	bot *tgbotapi.BotAPI
-	db  *sql.DB
+	API_TOKEN               string
+	ALLOWED_USER_ID         int64
+	DB_PATH                 string
+	allowedChats            = make(map[int64]bool)
+	secondaryChats          []int64
+	reportCopyTypes         = map[string]bool{"summary": true}
+	DEFAULT_TYPE            string
+	DESC_REQUIRED           = true
+	ROUNDING_MODE           = "nearest"
+	DISPLAY_DECIMALS        = 2
+	WHOLE_NUMBER_AMOUNTS    = false
+	CATEGORY_COLUMNS        = 1
+	DEBUG_TIMING            = false
+	STRIP_TAGS              = false
+	MAX_CATEGORIES          = 50
+	RETENTION_MONTHS        = 0
+	LARGE_AMOUNT_THRESHOLD  = 0.0
+	TRASH_DAYS              = 30
+	BASE_CURRENCY           = "IDR"
+	ROUND_UP_TO             = 0.0
+	FOLLOW_UP_PROMPT        = false
+	MONTHLY_BUDGET          = 0.0
+	MONTHLY_SAVINGS_GOAL    = 0.0
+	ENTRY_DECIMALS          = -1
+	CURRENCY_SYMBOL         string
+	UNAUTHORIZED_MESSAGE    = "You are not authorized to use this bot."
+	POLL_TIMEOUT            = 60
+	DAILY_NUDGE_HOUR        = -1
+	DIGEST_AFTER_DAYS       = 7
+	INCOME_ALERT_DAY        = 0
+	INCOME_ALERT_MESSAGE    = "No income logged this month yet. Did your salary arrive? /add"
+	FISCAL_YEAR_START_MONTH = 1
+	INGEST_SECRET           string
+	CONFIRM_CODES           = false
+	typeLabels              = map[string]string{"income": "Income", "expense": "Expense"}
+	typeOrder               = []string{"income", "expense"}
+	location                = time.FixedZone("GMT+7", 7*60*60)
+	categories              = []string{}
+	paymentMethods          = []string{"Cash", "Card", "E-wallet"}
+	bot                     *tgbotapi.BotAPI
+	db                      *sql.DB // Active profile's database
+	mainDB                  *sql.DB // DB_PATH, which also holds settings
 )
 
 type TransactionState struct {
+	ChatID          int64
 	UserID          int64
 	Step            string // Tracks current state step
 	TransactionType string // "income" or "expense"
 	Category        string
 	Amount          float64
 	Description     string
+	Date            time.Time          // Backdated timestamp, zero means now
+	Tags            []string           // #tags found in the description
+	TransactionID   int64              // Row being edited by maintenance flows
+	Queue           []int64            // Rows still waiting in a bulk flow
+	Processed       int                // Rows handled so far in a bulk flow
+	Suggested       string             // Category inferred from the description, if any
+	Pending         bool               // Save with status pending until /confirm
+	Reference       string             // Optional ref: token, e.g. an invoice number
+	Location        *tgbotapi.Location // Shared at the optional location step
+	PaymentMethod   string             // Picked at the optional payment step or with pay:
+	Breakdown       string             // "3 × 25,000" from a quantity entry, kept in the description
+	PromptMessageID int                // Confirmation prompt that reactions answer
+	Confirm         *confirmation      // Sensitive action waiting for its code
 }
 
-var userStates = make(map[int64]*TransactionState)
+// stateKey identifies a conversation; in group chats each member has
+// their own flow.
+type stateKey struct {
+	ChatID int64
+	UserID int64
+}
+
+var userStates = make(map[stateKey]*TransactionState)
+
+func clearState(state *TransactionState) {
+	delete(userStates, stateKey{state.ChatID, state.UserID})
+}
 
 func main() {
 	// Load environment variables
@@ -45,6 +109,167 @@ func main() {
 	ALLOWED_USER_ID, _ = strconv.ParseInt(os.Getenv("ALLOWED_USER_ID"), 10, 64)
 	DB_PATH = os.Getenv("DB_PATH")
 
+	// Chats whose members may use the bot; the allowed user's private chat
+	// always counts
+	allowedChats[ALLOWED_USER_ID] = true
+	for _, v := range strings.Split(os.Getenv("ALLOWED_CHAT_IDS"), ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		chatID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid chat ID %q in ALLOWED_CHAT_IDS", v)
+		}
+		allowedChats[chatID] = true
+	}
+
+	// Chats that get a copy of the owner's reports, e.g. an accountant
+	for _, v := range strings.Split(os.Getenv("SECONDARY_CHAT_ID"), ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		chatID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid chat ID %q in SECONDARY_CHAT_ID", v)
+		}
+		secondaryChats = append(secondaryChats, chatID)
+	}
+	if v, ok := os.LookupEnv("REPORT_COPY_TYPES"); ok {
+		reportCopyTypes = make(map[string]bool)
+		for _, t := range strings.Split(strings.ToLower(v), ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				reportCopyTypes[t] = true
+			}
+		}
+	}
+
+	// Optional settings that can later be overridden with /set
+	for _, def := range settingDefs {
+		if def.env == "" {
+			continue
+		}
+		if v := os.Getenv(def.env); v != "" {
+			if err := def.apply(v); err != nil {
+				log.Fatalf("Invalid %s %q: %v", def.env, v, err)
+			}
+		}
+	}
+
+	// Optional automatic deletion of old transactions
+	if v := os.Getenv("RETENTION_MONTHS"); v != "" {
+		RETENTION_MONTHS, err = strconv.Atoi(v)
+		if err != nil || RETENTION_MONTHS < 0 {
+			log.Fatalf("Invalid RETENTION_MONTHS %q", v)
+		}
+	}
+
+	// Long polling timeout in seconds
+	if v := os.Getenv("POLL_TIMEOUT"); v != "" {
+		POLL_TIMEOUT, err = strconv.Atoi(v)
+		if err != nil || POLL_TIMEOUT < 0 {
+			log.Fatalf("Invalid POLL_TIMEOUT %q", v)
+		}
+	}
+
+	// Days deleted transactions stay restorable; 0 keeps them until /empty_trash
+	if v := os.Getenv("TRASH_DAYS"); v != "" {
+		TRASH_DAYS, err = strconv.Atoi(v)
+		if err != nil || TRASH_DAYS < 0 {
+			log.Fatalf("Invalid TRASH_DAYS %q", v)
+		}
+	}
+
+	// Optional daily reminder, coalesced into a weekly digest when idle
+	if v := os.Getenv("DAILY_NUDGE_HOUR"); v != "" {
+		DAILY_NUDGE_HOUR, err = strconv.Atoi(v)
+		if err != nil || DAILY_NUDGE_HOUR < -1 || DAILY_NUDGE_HOUR > 23 {
+			log.Fatalf("Invalid DAILY_NUDGE_HOUR %q", v)
+		}
+	}
+	if v := os.Getenv("DIGEST_AFTER_DAYS"); v != "" {
+		DIGEST_AFTER_DAYS, err = strconv.Atoi(v)
+		if err != nil || DIGEST_AFTER_DAYS < 0 {
+			log.Fatalf("Invalid DIGEST_AFTER_DAYS %q", v)
+		}
+	}
+
+	// Day of the month income is expected by; 0 disables the alert
+	if v := os.Getenv("INCOME_ALERT_DAY"); v != "" {
+		INCOME_ALERT_DAY, err = strconv.Atoi(v)
+		if err != nil || INCOME_ALERT_DAY < 0 || INCOME_ALERT_DAY > 28 {
+			log.Fatalf("Invalid INCOME_ALERT_DAY %q", v)
+		}
+	}
+	if v := os.Getenv("INCOME_ALERT_MESSAGE"); v != "" {
+		INCOME_ALERT_MESSAGE = v
+	}
+
+	// First month of the fiscal year used by /year; 1 keeps calendar years
+	if v := os.Getenv("FISCAL_YEAR_START_MONTH"); v != "" {
+		FISCAL_YEAR_START_MONTH, err = strconv.Atoi(v)
+		if err != nil || FISCAL_YEAR_START_MONTH < 1 || FISCAL_YEAR_START_MONTH > 12 {
+			log.Fatalf("Invalid FISCAL_YEAR_START_MONTH %q", v)
+		}
+	}
+
+	// Require a typed code before /purge and /empty_trash
+	if v := os.Getenv("CONFIRM_CODES"); v != "" {
+		CONFIRM_CODES, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid CONFIRM_CODES %q", v)
+		}
+	}
+
+	// Static exchange rates for /convert and foreign amounts in /add
+	if v := os.Getenv("BASE_CURRENCY"); v != "" {
+		BASE_CURRENCY = strings.ToUpper(strings.TrimSpace(v))
+		if !isCurrencyCode(BASE_CURRENCY) {
+			log.Fatalf("Invalid BASE_CURRENCY %q", v)
+		}
+	}
+	rates, err := parseCurrencyRates(os.Getenv("CURRENCY_RATES"))
+	if err != nil {
+		log.Fatalf("Invalid CURRENCY_RATES: %v", err)
+	}
+	exchangeRates = rates
+
+	// Labels and order of the Income/Expense buttons
+	for transactionType, env := range map[string]string{"income": "INCOME_LABEL", "expense": "EXPENSE_LABEL"} {
+		if v, ok := os.LookupEnv(env); ok {
+			if strings.TrimSpace(v) == "" {
+				log.Fatalf("%s must not be empty", env)
+			}
+			typeLabels[transactionType] = strings.TrimSpace(v)
+		}
+	}
+	if v := os.Getenv("TYPE_ORDER"); v != "" {
+		order := strings.Split(strings.ToLower(strings.ReplaceAll(v, " ", "")), ",")
+		if len(order) != 2 || order[0] == order[1] || typeLabels[order[0]] == "" || typeLabels[order[1]] == "" {
+			log.Fatalf("Invalid TYPE_ORDER %q, use income,expense or expense,income", v)
+		}
+		typeOrder = order
+	}
+
+	// Short command names, e.g. "a=add,s=summary"
+	if err := parseCommandAliases(os.Getenv("COMMAND_ALIASES")); err != nil {
+		log.Fatalf("Invalid COMMAND_ALIASES: %v", err)
+	}
+
+	// What people who aren't allowed to use the bot see
+	if v := os.Getenv("UNAUTHORIZED_MESSAGE"); v != "" {
+		UNAUTHORIZED_MESSAGE = v
+	}
+	if v, ok := os.LookupEnv("PUBLIC_COMMANDS"); ok {
+		if err := parsePublicCommands(v); err != nil {
+			log.Fatalf("Invalid PUBLIC_COMMANDS: %v", err)
+		}
+	}
+
+	// Reply keyboard buttons, e.g. "Add,Summary,Weekly"
+	if v, ok := os.LookupEnv("QUICK_BUTTONS"); ok {
+		parseQuickButtons(v)
+	}
+
 	// Parse categories
 	catStr := os.Getenv("CATEGORIES")
 	if catStr != "" {
@@ -58,6 +283,26 @@ func main() {
 			"Transportation", "Utilities", "Rent", "Bills",
 		}
 	}
+	if v := os.Getenv("MAX_CATEGORIES"); v != "" {
+		MAX_CATEGORIES, err = strconv.Atoi(v)
+		if err != nil || MAX_CATEGORIES < 1 {
+			log.Fatalf("Invalid MAX_CATEGORIES %q", v)
+		}
+	}
+	if err := checkCategoryLimit(len(categories)); err != nil {
+		log.Printf("CATEGORIES is misconfigured: %v; only the first %d will be used", err, MAX_CATEGORIES)
+		categories = categories[:MAX_CATEGORIES]
+	}
+
+	// Payment methods offered at the optional step, e.g. "Cash,Card,OVO"
+	if v, ok := os.LookupEnv("PAYMENT_METHODS"); ok {
+		paymentMethods = nil
+		for _, method := range strings.Split(v, ",") {
+			if method = strings.TrimSpace(method); method != "" {
+				paymentMethods = append(paymentMethods, method)
+			}
+		}
+	}
 
 	// Initialize bot
 	bot, err = tgbotapi.NewBotAPI(API_TOKEN)
@@ -65,62 +310,371 @@ func main() {
 		log.Panic(err)
 	}
 
+	// Optional encryption of descriptions at rest
+	if key := os.Getenv("DB_ENCRYPTION_KEY"); key != "" {
+		if err = initEncryption(key); err != nil {
+			log.Panic(err)
+		}
+	}
+
 	// Initialize database
-	db, err = sql.Open("sqlite", DB_PATH)
+	mainDB, err = openDatabase(DB_PATH)
 	if err != nil {
 		log.Panic(err)
 	}
-	defer db.Close()
-
-	// Create transactions table if not exists
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS transactions (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		type TEXT NOT NULL,
-		category TEXT NOT NULL,
-		amount REAL NOT NULL,
-		description TEXT,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	)`)
-	if err != nil {
+	defer mainDB.Close()
+	db = mainDB
+	loadGlobalSettings()
+	loadStoredCategories()
+
+	// Optional extra databases, e.g. "business=/data/business.db"
+	if err = openProfiles(os.Getenv("PROFILES")); err != nil {
 		log.Panic(err)
 	}
 
+	// Optional monthly CSV backups
+	if dir := os.Getenv("EXPORT_DIR"); dir != "" {
+		exportDestination = dirUploader{dir: dir}
+	}
+
+	// Optional voice entry at the amount step
+	if url := os.Getenv("TRANSCRIBE_URL"); url != "" {
+		transcriber = newHTTPTranscriber(url, os.Getenv("TRANSCRIBE_API_KEY"), os.Getenv("TRANSCRIBE_MODEL"))
+	}
+
+	startScheduler()
+
+	// Shared secret for POST /ingest on the HEALTH_PORT server
+	INGEST_SECRET = os.Getenv("INGEST_SECRET")
+	if port := os.Getenv("HEALTH_PORT"); port != "" {
+		startHealthServer(port)
+	} else if INGEST_SECRET != "" {
+		log.Printf("INGEST_SECRET is set but HEALTH_PORT is not; the ingest endpoint is disabled")
+	}
+
 	bot.Debug = true
 	log.Printf("Authorized on account %s", bot.Self.UserName)
 
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
+	// Resume after the last update handled before a restart, so nothing
+	// is processed twice
+	offset, _ := strconv.Atoi(getSetting(globalSettingsUser, "update_offset", "0"))
+	u := tgbotapi.NewUpdate(offset)
+	u.Timeout = POLL_TIMEOUT
 
-	updates := bot.GetUpdatesChan(u)
+	updates := pollUpdates(u)
 
-	for update := range updates {
-		if update.Message != nil {
-			handleMessage(update.Message)
-		} else if update.CallbackQuery != nil {
-			handleCallbackQuery(update.CallbackQuery)
+	// Everything that touches shared state runs here, one event at a time;
+	// the ingest endpoint, voice transcription and the scheduler hand their
+	// work over through ingestJobs, voiceResults and scheduledRuns
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			markUpdateReceived()
+			if update.Message != nil {
+				handleMessage(update.Message)
+			} else if update.CallbackQuery != nil {
+				handleCallbackQuery(update.CallbackQuery)
+			} else if update.MessageReaction != nil {
+				handleReaction(update.MessageReaction)
+			}
+			if err := setSetting(globalSettingsUser, "update_offset", strconv.Itoa(update.UpdateID+1)); err != nil {
+				log.Printf("Failed to save update offset: %v", err)
+			}
+		case job := <-ingestJobs:
+			job.reply <- processIngest(job.req)
+		case result := <-voiceResults:
+			applyVoiceAmount(result)
+		case <-scheduledRuns:
+			runScheduledJobs()
 		}
 	}
 }
 
 func handleMessage(message *tgbotapi.Message) {
 	userID := message.From.ID
-	if userID != ALLOWED_USER_ID {
-		sendMessage(message.Chat.ID, "You are not authorized to use this bot.")
+	resumeChat(message.Chat.ID)
+
+	if !isAuthorized(message.Chat.ID, userID) {
+		handleUnauthorized(message)
 		return
 	}
+	if userID == ALLOWED_USER_ID {
+		noteInteraction()
+	}
+	key := stateKey{message.Chat.ID, userID}
+
+	// Replying to a confirmation with a new amount edits that transaction
+	if message.ReplyToMessage != nil && !message.IsCommand() && editByReply(message) {
+		return
+	}
+
+	command := resolveAlias(message.Command())
+	_, inFlow := userStates[key]
+	// Button labels are only commands outside a flow, where the same text
+	// could be a description or a category name
+	if command == "" && !inFlow {
+		if c, ok := quickButtonCommand(message.Text); ok {
+			command = c
+		}
+	}
+	// A mistyped "/50000" in the middle of a flow is input for the current
+	// step, not an unknown command
+	if inFlow && message.IsCommand() && !knownCommands[command] {
+		message.Text = strings.TrimPrefix(message.Text, "/")
+		message.Entities = nil
+		command = ""
+	}
+
+	if command != "" && getSetting(userID, "timing", strconv.FormatBool(DEBUG_TIMING)) == "true" {
+		startTimer(message.Chat.ID, command)
+		// Commands that answered without a text message, or not at all,
+		// still get the timing on its own
+		defer func() {
+			if timer := takeTimer(message.Chat.ID); timer != nil {
+				sendMessage(message.Chat.ID, timer.annotate(""))
+			}
+		}()
+	}
 
-	switch message.Command() {
-	case "add":
-		startTransaction(message.Chat.ID, userID)
+	switch command {
+	case "whoami":
+		showWhoAmI(message)
+	case "start":
+		sendMessageWithMenu(message.Chat.ID, "Hi! Use /add to log a transaction or /summary to see this month.")
+	case "add", "income", "expense":
+		transactionType := ""
+		if command != "add" {
+			transactionType = command
+		}
+		pending := false
+		switch strings.ToLower(strings.TrimSpace(message.CommandArguments())) {
+		case "":
+		case "pending":
+			pending = true
+		default:
+			sendMessage(message.Chat.ID, fmt.Sprintf("Usage: /%s [pending]", command))
+			return
+		}
+		if _, exists := userStates[key]; exists {
+			confirmRestartTransaction(message.Chat.ID, transactionType, pending)
+			return
+		}
+		startTransaction(message.Chat.ID, userID, transactionType, pending)
 	case "summary":
-		showSummary(message.Chat.ID)
+		showSummary(message.Chat.ID, userID, message.CommandArguments())
+	case "confirm":
+		confirmPending(message.Chat.ID, message.CommandArguments())
+	case "export":
+		exportTransactions(message.Chat.ID, message.CommandArguments())
+	case "export_category":
+		exportCategory(message.Chat.ID, message.CommandArguments())
+	case "summary_by_description":
+		showSummaryByDescription(message.Chat.ID, message.CommandArguments())
+	case "summary_qr":
+		sendSummaryQR(message.Chat.ID, message.CommandArguments())
+	case "summary_pie":
+		showSummaryPie(message.Chat.ID, message.CommandArguments())
+	case "summary_export":
+		summaryExport(message.Chat.ID, userID, message.CommandArguments())
+	case "purge":
+		confirmPurge(message.Chat.ID, message.CommandArguments())
+	case "lastmonth":
+		showLastMonth(message.Chat.ID, userID)
+	case "diff":
+		showMonthDiff(message.Chat.ID, message.CommandArguments())
 	case "get_latest_report":
 		get_latest_report(message.Chat.ID)
 	case "get_weekly_expense":
 		get_weekly_expense_report(message.Chat.ID)
+	case "merge_categories":
+		// Categories are shared by every chat, and so are the rows they rewrite
+		if userID != ALLOWED_USER_ID {
+			sendMessage(message.Chat.ID, "Only the bot owner can use /merge_categories.")
+			return
+		}
+		mergeCategories(message.Chat.ID, message.CommandArguments())
+	case "managecategories":
+		if userID != ALLOWED_USER_ID {
+			sendMessage(message.Chat.ID, "Only the bot owner can use /managecategories.")
+			return
+		}
+		if _, exists := userStates[key]; exists {
+			sendMessage(message.Chat.ID, "Please finish the current transaction first.")
+			return
+		}
+		startManageCategories(message.Chat.ID, userID)
+	case "cancel":
+		if state, exists := userStates[key]; exists {
+			clearState(state)
+			sendMessage(message.Chat.ID, "Cancelled.")
+			sendFollowUp(message.Chat.ID, userID)
+		} else {
+			sendMessage(message.Chat.ID, "There is nothing to cancel.")
+		}
+	case "cancel_all":
+		if userID != ALLOWED_USER_ID {
+			sendMessage(message.Chat.ID, "Only the bot owner can use /cancel_all.")
+			return
+		}
+		count := len(userStates)
+		userStates = make(map[stateKey]*TransactionState)
+		log.Printf("User %d cleared all %d conversation state(s) with /cancel_all", userID, count)
+		sendMessage(message.Chat.ID, fmt.Sprintf("Cleared %d conversation(s) in progress.", count))
+	case "states":
+		if userID != ALLOWED_USER_ID {
+			sendMessage(message.Chat.ID, "Only the bot owner can use /states.")
+			return
+		}
+		showStates(message.Chat.ID)
+	case "skip":
+		state, exists := userStates[key]
+		if exists && state.Step == "ENTER_LOCATION" {
+			saveTransaction(message.Chat.ID, state)
+			return
+		}
+		if exists && state.Step == "SELECT_PAYMENT_METHOD" {
+			promptLocation(message.Chat.ID, state)
+			return
+		}
+		if !exists || state.Step != "ENTER_DESCRIPTION" {
+			sendMessage(message.Chat.ID, "There is nothing to skip right now.")
+			return
+		}
+		if DESC_REQUIRED {
+			sendMessage(message.Chat.ID, "A description is required for every transaction.")
+			return
+		}
+		state.Description = ""
+		finishDescription(message.Chat.ID, state)
+	case "find_ref":
+		findByReference(message.Chat.ID, message.CommandArguments())
+	case "delete":
+		deleteTransaction(message.Chat.ID, message.CommandArguments())
+	case "restore":
+		restoreTransaction(message.Chat.ID, message.CommandArguments())
+	case "trash":
+		showTrash(message.Chat.ID)
+	case "empty_trash":
+		emptyTrash(message.Chat.ID, userID)
+	case "show":
+		showTransaction(message.Chat.ID, message.CommandArguments())
+	case "pin":
+		pinMetrics(message.Chat.ID)
+	case "setcurrency":
+		setCurrencySymbol(message.Chat.ID, userID, message.CommandArguments())
+	case "convert":
+		handleConvert(message.Chat.ID, message.CommandArguments())
+	case "setdate":
+		setTransactionDate(message.Chat.ID, message.CommandArguments())
+	case "note":
+		noteLatest(message.Chat.ID, message.CommandArguments())
+	case "find_amount", "find_by_amount":
+		findByAmount(message.Chat.ID, message.CommandArguments())
+	case "tag":
+		showTag(message.Chat.ID, message.CommandArguments())
+	case "category":
+		showCategoryTransactions(message.Chat.ID, message.CommandArguments())
+	case "category_range":
+		handleCategoryRange(message.Chat.ID, message.CommandArguments())
+	case "category_avg":
+		showCategoryAverages(message.Chat.ID, message.CommandArguments())
+	case "category_trend":
+		showCategoryTrend(message.Chat.ID, message.CommandArguments())
+	case "recategorize":
+		if _, exists := userStates[key]; exists {
+			sendMessage(message.Chat.ID, "Please finish the current transaction first.")
+			return
+		}
+		startRecategorize(message.Chat.ID, userID, message.CommandArguments())
+	case "uncategorized":
+		if _, exists := userStates[key]; exists {
+			sendMessage(message.Chat.ID, "Please finish the current transaction first.")
+			return
+		}
+		startAssignCategories(message.Chat.ID, userID)
+	case "goal", "goals":
+		handleGoal(message.Chat.ID, message.CommandArguments())
+	case "quick":
+		logQuickEntry(message.Chat.ID, userID, message.CommandArguments())
+	case "quick_add":
+		addQuickEntry(message.Chat.ID, message.CommandArguments())
+	case "quick_delete":
+		deleteQuickEntry(message.Chat.ID, message.CommandArguments())
+	case "quick_list":
+		showQuickEntries(message.Chat.ID)
+	case "recurring":
+		showRecurring(message.Chat.ID)
+	case "upcoming":
+		showUpcoming(message.Chat.ID)
+	case "recurring_add":
+		addRecurring(message.Chat.ID, message.CommandArguments())
+	case "suggest_recurring":
+		suggestRecurring(message.Chat.ID)
+	case "recurring_delete":
+		deleteRecurring(message.Chat.ID, message.CommandArguments())
+	case "profile":
+		handleProfile(message.Chat.ID, userID, message.CommandArguments())
+	case "settings":
+		showSettings(message.Chat.ID, userID)
+	case "set":
+		handleSet(message.Chat.ID, userID, message.CommandArguments())
+	case "query":
+		runReadOnlyQuery(message.Chat.ID, userID, message.CommandArguments())
+	case "inference":
+		showInferenceAccuracy(message.Chat.ID)
+	case "summary_categories_all":
+		showCategoriesAllTime(message.Chat.ID)
+	case "networth":
+		showNetWorth(message.Chat.ID)
+	case "monthly_goal":
+		showMonthlyGoal(message.Chat.ID)
+	case "savings_rate":
+		showSavingsRate(message.Chat.ID, message.CommandArguments())
+	case "streak":
+		showStreak(message.Chat.ID, message.CommandArguments())
+	case "dbinfo":
+		showDBInfo(message.Chat.ID)
+	case "year":
+		showYear(message.Chat.ID, message.CommandArguments())
+	case "merge_duplicates":
+		confirmMergeDuplicates(message.Chat.ID)
+	case "rebuild_cache":
+		rebuildCache(message.Chat.ID)
+	case "by_method":
+		showByMethod(message.Chat.ID, message.CommandArguments())
+	case "byhour":
+		showByHour(message.Chat.ID, message.CommandArguments())
+	case "byweekday":
+		showByWeekday(message.Chat.ID, message.CommandArguments())
 	default:
-		if state, exists := userStates[userID]; exists {
+		if state, exists := userStates[key]; exists {
+			if state.Step == "ENTER_LOCATION" {
+				processLocation(message, state)
+				return
+			}
+			if state.Step == "ENTER_AMOUNT" && message.Voice != nil {
+				processVoiceAmount(message, state)
+				return
+			}
+			// Stickers, photos and other media carry no text to parse
+			if message.Text == "" {
+				sendMessage(message.Chat.ID, nonTextPrompt(state))
+				return
+			}
 			switch state.Step {
+			case "SELECT_CATEGORY":
+				processTypedCategory(message, state)
+			case "CATEGORY_ADD", "CATEGORY_RENAME":
+				processCategoryName(message, state)
+			case "RETRY_SAVE":
+				sendMessage(message.Chat.ID, nonTextPrompt(state))
+			case "SELECT_PAYMENT_METHOD":
+				processTypedPaymentMethod(message, state)
+			case "CONFIRM_CODE":
+				processConfirmCode(message, state)
 			case "ENTER_AMOUNT":
 				processAmount(message, state)
 			case "ENTER_DESCRIPTION":
@@ -132,15 +686,124 @@ func handleMessage(message *tgbotapi.Message) {
 	}
 }
 
+// showStates dumps every conversation in progress, for finding out why
+// someone is stuck. Clear them with /cancel or /cancel_all.
+func showStates(chatID int64) {
+	if len(userStates) == 0 {
+		sendMessage(chatID, "No conversations in progress.")
+		return
+	}
+	keys := make([]stateKey, 0, len(userStates))
+	for key := range userStates {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ChatID != keys[j].ChatID {
+			return keys[i].ChatID < keys[j].ChatID
+		}
+		return keys[i].UserID < keys[j].UserID
+	})
+
+	text := fmt.Sprintf("%d conversation(s) in progress:\n", len(keys))
+	for _, key := range keys {
+		s := userStates[key]
+		text += fmt.Sprintf("\nchat %d, user %d: %s\n", key.ChatID, key.UserID, s.Step)
+		text += fmt.Sprintf("  type=%q category=%q amount=%s description=%q\n", s.TransactionType, s.Category, formatAmount(s.Amount), s.Description)
+		if !s.Date.IsZero() {
+			text += "  date=" + s.Date.Format("2006-01-02") + "\n"
+		}
+		if s.Pending || s.Reference != "" || len(s.Tags) > 0 {
+			text += fmt.Sprintf("  pending=%t reference=%q tags=%v\n", s.Pending, s.Reference, s.Tags)
+		}
+		if s.TransactionID != 0 || len(s.Queue) > 0 {
+			text += fmt.Sprintf("  transaction=#%d queued=%d processed=%d\n", s.TransactionID, len(s.Queue), s.Processed)
+		}
+	}
+	sendLongMessage(chatID, text)
+}
+
+func showWhoAmI(message *tgbotapi.Message) {
+	text := fmt.Sprintf("Your Telegram ID: %d", message.From.ID)
+	if message.From.UserName != "" {
+		text += fmt.Sprintf("\nUsername: @%s", message.From.UserName)
+	}
+	if message.Chat.ID != message.From.ID {
+		text += fmt.Sprintf("\nThis chat's ID: %d", message.Chat.ID)
+	}
+	sendMessage(message.Chat.ID, text)
+}
+
+// isAuthorized allows the configured user anywhere and every member of an
+// allowed chat. Data is scoped by chat, so group members share a ledger.
+func isAuthorized(chatID int64, userID int64) bool {
+	return userID == ALLOWED_USER_ID || allowedChats[chatID]
+}
+
+// nonTextPrompt reminds the user what the current step expects when they
+// send something other than text.
+func nonTextPrompt(state *TransactionState) string {
+	switch state.Step {
+	case "SELECT_CATEGORY":
+		return "Please use the buttons above or type the category name."
+	case "CATEGORY_ADD", "CATEGORY_RENAME":
+		return "Please send the category name as text, or /cancel."
+	case "RETRY_SAVE":
+		return "Tap Retry save above, or /cancel to discard the transaction."
+	case "SELECT_PAYMENT_METHOD":
+		return "Please pick a payment method above or type its name, or /skip."
+	case "CONFIRM_CODE":
+		return "Please reply with the confirmation code, or /cancel."
+	case "ENTER_AMOUNT":
+		return "Please send the amount as a number."
+	case "ENTER_DESCRIPTION":
+		if DESC_REQUIRED {
+			return "Please send the description as text."
+		}
+		return "Please send the description as text, or /skip to leave it empty."
+	default:
+		return "Please use the buttons above to continue."
+	}
+}
+
 func handleCallbackQuery(callback *tgbotapi.CallbackQuery) {
 	userID := callback.From.ID
-	if userID != ALLOWED_USER_ID {
-		sendMessage(callback.Message.Chat.ID, "You are not authorized to use this bot.")
+	if !isAuthorized(callback.Message.Chat.ID, userID) {
+		answerCallback(callback, "Not authorized")
+		return
+	}
+	if userID == ALLOWED_USER_ID {
+		noteInteraction()
+	}
+	key := stateKey{callback.Message.Chat.ID, userID}
+
+	if strings.HasPrefix(callback.Data, "restart:yes") {
+		transactionType, mode, _ := strings.Cut(strings.TrimPrefix(callback.Data, "restart:yes:"), ":")
+		editMessage(callback.Message.Chat.ID, callback.Message.MessageID, "Previous transaction discarded.")
+		startTransaction(callback.Message.Chat.ID, userID, transactionType, mode == "pending")
+		answerCallback(callback, "Restarted")
+		return
+	}
+	if strings.HasPrefix(callback.Data, "purge:") {
+		processPurge(callback)
+		return
+	}
+	if strings.HasPrefix(callback.Data, "dupes:") {
+		processMergeDuplicates(callback)
+		return
+	}
+	if strings.HasPrefix(callback.Data, "suggest:") {
+		processSuggestRecurring(callback)
+		return
+	}
+	if callback.Data == "restart:no" {
+		editMessage(callback.Message.Chat.ID, callback.Message.MessageID, "Continuing your current transaction.")
+		answerCallback(callback, "")
 		return
 	}
 
-	state, exists := userStates[userID]
+	state, exists := userStates[key]
 	if !exists {
+		answerCallback(callback, "This button has expired")
 		return
 	}
 
@@ -148,112 +811,610 @@ func handleCallbackQuery(callback *tgbotapi.CallbackQuery) {
 	case "SELECT_TYPE":
 		processTransactionType(callback, state)
 	case "SELECT_CATEGORY":
+		if callback.Data == "switch_type" {
+			switchTransactionType(callback, state)
+			return
+		}
 		processCategory(callback, state)
+	case "ASSIGN_CATEGORY":
+		processAssignCategory(callback, state)
+	case "RECATEGORIZE":
+		processRecategorize(callback, state)
+	case "MANAGE_CATEGORIES":
+		processManageCategories(callback, state)
+	case "CONFIRM_AMOUNT":
+		processConfirmAmount(callback, state)
+	case "CONFIRM_CATEGORY":
+		processInferredCategory(callback, state)
+	case "RETRY_SAVE":
+		processRetrySave(callback, state)
+	case "SELECT_PAYMENT_METHOD":
+		processPaymentMethod(callback, state)
 	}
 }
 
-func startTransaction(chatID int64, userID int64) {
+// startTransaction begins the /add flow. An empty transactionType falls back
+// to DEFAULT_TYPE, and if that is unset the user is asked to pick one.
+// Pending transactions are left out of summaries until confirmed.
+func startTransaction(chatID int64, userID int64, transactionType string, pending bool) {
+	if transactionType == "" {
+		transactionType = DEFAULT_TYPE
+	}
+
 	state := &TransactionState{
-		UserID: userID,
-		Step:   "SELECT_TYPE",
+		ChatID:  chatID,
+		UserID:  userID,
+		Step:    "SELECT_TYPE",
+		Pending: pending,
 	}
-	userStates[userID] = state
+	userStates[stateKey{chatID, userID}] = state
 
+	if transactionType != "" {
+		state.TransactionType = transactionType
+		state.Step = "SELECT_CATEGORY"
+		sendMessageWithKeyboard(chatID, fmt.Sprintf("New %s. Choose a category:", transactionType), categoryKeyboard(chatID, transactionType))
+		return
+	}
+
+	// Callback data stays "income"/"expense" whatever the labels say
+	row := make([]tgbotapi.InlineKeyboardButton, 0, len(typeOrder))
+	for _, t := range typeOrder {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(typeLabels[t], t))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(row)
+	sendMessageWithKeyboard(chatID, "Please choose the type of transaction:", keyboard)
+}
+
+func confirmRestartTransaction(chatID int64, transactionType string, pending bool) {
+	data := "restart:yes:" + transactionType
+	if pending {
+		data += ":pending"
+	}
 	buttons := [][]tgbotapi.InlineKeyboardButton{
 		{
-			tgbotapi.NewInlineKeyboardButtonData("Income", "income"),
-			tgbotapi.NewInlineKeyboardButtonData("Expense", "expense"),
+			tgbotapi.NewInlineKeyboardButtonData("Yes", data),
+			tgbotapi.NewInlineKeyboardButtonData("No", "restart:no"),
 		},
 	}
 	keyboard := tgbotapi.NewInlineKeyboardMarkup(buttons...)
-	sendMessageWithKeyboard(chatID, "Please choose the type of transaction:", keyboard)
+	sendMessageWithKeyboard(chatID, "You have a transaction in progress — restart?", keyboard)
 }
 
 func processTransactionType(callback *tgbotapi.CallbackQuery, state *TransactionState) {
 	state.TransactionType = callback.Data
 	state.Step = "SELECT_CATEGORY"
 
+	text := fmt.Sprintf("You selected %s. Choose a category:", state.TransactionType)
+	keyboard := categoryKeyboard(callback.Message.Chat.ID, state.TransactionType)
+	if err := editMessageWithKeyboard(callback.Message.Chat.ID, callback.Message.MessageID, text, keyboard); err != nil {
+		sendMessageWithKeyboard(callback.Message.Chat.ID, text, keyboard)
+	}
+	answerCallback(callback, "")
+}
+
+// switchTransactionType flips between income and expense while the
+// category keyboard is shown, for when DEFAULT_TYPE picked the wrong one.
+func switchTransactionType(callback *tgbotapi.CallbackQuery, state *TransactionState) {
+	if state.TransactionType == "income" {
+		state.TransactionType = "expense"
+	} else {
+		state.TransactionType = "income"
+	}
+
+	text := fmt.Sprintf("Switched to %s. Choose a category:", state.TransactionType)
+	keyboard := categoryKeyboard(callback.Message.Chat.ID, state.TransactionType)
+	if err := editMessageWithKeyboard(callback.Message.Chat.ID, callback.Message.MessageID, text, keyboard); err != nil {
+		sendMessageWithKeyboard(callback.Message.Chat.ID, text, keyboard)
+	}
+	answerCallback(callback, "Switched to "+state.TransactionType)
+}
+
+// categoryButtons lays the categories out CATEGORY_COLUMNS per row, using
+// the category name as callback data.
+func categoryButtons() [][]tgbotapi.InlineKeyboardButton {
 	buttons := make([][]tgbotapi.InlineKeyboardButton, 0)
-	for _, category := range categories {
-		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(category, category),
-		))
+	for i := 0; i < len(categories); i += CATEGORY_COLUMNS {
+		end := i + CATEGORY_COLUMNS
+		if end > len(categories) {
+			end = len(categories)
+		}
+		row := make([]tgbotapi.InlineKeyboardButton, 0, end-i)
+		for _, category := range categories[i:end] {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(category, category))
+		}
+		buttons = append(buttons, row)
 	}
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(buttons...)
-	editMessageWithKeyboard(
-		callback.Message.Chat.ID,
-		callback.Message.MessageID,
-		fmt.Sprintf("You selected %s. Choose a category:", state.TransactionType),
-		keyboard,
-	)
+	return buttons
+}
+
+// recentCategoryCount is how many recently used categories are offered
+// above the full list.
+const recentCategoryCount = 3
+
+// recentCategories returns the chat's most recently used categories of
+// transactionType that are still configured, newest first.
+func recentCategories(chatID int64, transactionType string) []string {
+	rows, err := db.Query("SELECT category FROM transactions WHERE chat_id = ? AND deleted_at IS NULL AND type = ? ORDER BY created_at DESC, id DESC LIMIT 30",
+		chatID, transactionType)
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var recent []string
+	seen := make(map[string]bool)
+	for rows.Next() && len(recent) < recentCategoryCount {
+		var category string
+		if err := rows.Scan(&category); err != nil {
+			log.Printf("Row scan error: %v", err)
+			continue
+		}
+		if category, ok := findCategory(category); ok && !seen[category] {
+			seen[category] = true
+			recent = append(recent, category)
+		}
+	}
+	return recent
+}
+
+// categoryKeyboard offers the recently used categories in a row of their
+// own, then every category and the type switch.
+func categoryKeyboard(chatID int64, transactionType string) tgbotapi.InlineKeyboardMarkup {
+	buttons := categoryButtons()
+	if recent := recentCategories(chatID, transactionType); len(recent) > 0 {
+		row := make([]tgbotapi.InlineKeyboardButton, 0, len(recent))
+		for _, category := range recent {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData("🕘 "+category, category))
+		}
+		buttons = append([][]tgbotapi.InlineKeyboardButton{row}, buttons...)
+	}
+
+	other := "income"
+	if transactionType == "income" {
+		other = "expense"
+	}
+	buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("↔ Switch to "+typeLabels[other], "switch_type"),
+	))
+	return tgbotapi.NewInlineKeyboardMarkup(buttons...)
 }
 
 func processCategory(callback *tgbotapi.CallbackQuery, state *TransactionState) {
 	state.Category = callback.Data
 	state.Step = "ENTER_AMOUNT"
 
-	editMessage(
-		callback.Message.Chat.ID,
-		callback.Message.MessageID,
-		fmt.Sprintf("Selected category: %s. Enter the transaction amount.", state.Category),
-	)
+	text := fmt.Sprintf("Selected category: %s. Enter the transaction amount.", state.Category)
+	if err := editMessage(callback.Message.Chat.ID, callback.Message.MessageID, text); err != nil {
+		sendMessage(callback.Message.Chat.ID, text)
+	}
+	answerCallback(callback, "Category selected")
 }
 
 func processAmount(message *tgbotapi.Message, state *TransactionState) {
-	amount, err := strconv.ParseFloat(message.Text, 64)
-	if err != nil || amount <= 0 {
-		sendMessage(message.Chat.ID, "Invalid amount. Please enter a positive number.")
+	text, currency := splitCurrency(message.Text)
+	amount, breakdown, isQuantity, err := parseQuantityEntry(text)
+	if isQuantity && err != nil {
+		sendMessage(message.Chat.ID, fmt.Sprintf("Invalid quantity entry: %v. Use <quantity>x<unit price>, e.g. 3x25000.", err))
 		return
 	}
+	if !isQuantity {
+		amount, err = parseAmount(text)
+		if err != nil || amount <= 0 {
+			sendMessage(message.Chat.ID, invalidAmountMessage())
+			return
+		}
+	}
+	if breakdown != "" && currency != "" {
+		breakdown += " " + currency
+	}
+	state.Breakdown = breakdown
+	// Amounts in another currency are converted to BASE_CURRENCY
+	if currency != "" && currency != BASE_CURRENCY {
+		converted, err := convertAmount(amount, currency, BASE_CURRENCY)
+		if err != nil {
+			sendMessage(message.Chat.ID, fmt.Sprintf("Can't convert: %v. Enter the amount in %s instead.", err, BASE_CURRENCY))
+			return
+		}
+		sendMessage(message.Chat.ID, fmt.Sprintf("%s %s converted to %s %s.", formatNumber(amount), currency, formatNumber(converted), BASE_CURRENCY))
+		amount = converted
+	}
+	if amount > maxAmount {
+		sendMessage(message.Chat.ID, "Amount is too large.")
+		return
+	}
+	if rounded := roundEntryAmount(amount); rounded != amount {
+		if rounded <= 0 {
+			sendMessage(message.Chat.ID, invalidAmountMessage())
+			return
+		}
+		sendMessage(message.Chat.ID, fmt.Sprintf("Rounded %s to %s.", strconv.FormatFloat(amount, 'f', -1, 64), formatAmount(rounded)))
+		amount = rounded
+	}
 
 	state.Amount = amount
+	if LARGE_AMOUNT_THRESHOLD > 0 && amount > LARGE_AMOUNT_THRESHOLD {
+		promptConfirmAmount(message.Chat.ID, state, fmt.Sprintf("%s is a large amount", formatAmount(amount)))
+		return
+	}
+	// Amounts outside the category's expected range are likely typos
+	r, ok, err := queryCategoryRange(state.Category)
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+	}
+	if ok && !r.contains(amount) {
+		promptConfirmAmount(message.Chat.ID, state, fmt.Sprintf("%s is outside the usual range for %s (%s)", formatAmount(amount), state.Category, r))
+		return
+	}
+	promptDescription(message.Chat.ID, state)
+}
+
+// promptConfirmAmount asks whether an unusual amount is right, explaining
+// why with reason.
+func promptConfirmAmount(chatID int64, state *TransactionState, reason string) {
+	state.Step = "CONFIRM_AMOUNT"
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Yes", "amount:yes"),
+		tgbotapi.NewInlineKeyboardButtonData("No", "amount:no"),
+	))
+	sent := sendMessageWithKeyboard(chatID, reason+" — confirm? You can also react with 👍 or 👎.", keyboard)
+	state.PromptMessageID = sent.MessageID
+}
+
+func promptDescription(chatID int64, state *TransactionState) {
 	state.Step = "ENTER_DESCRIPTION"
-	sendMessage(message.Chat.ID, "Enter a description for the transaction (max 100 characters).")
+	if DESC_REQUIRED {
+		sendMessage(chatID, "Enter a description for the transaction (max 100 characters). Add @YYYY-MM-DD to backdate it or ref:<number> to attach a reference.")
+	} else {
+		sendMessage(chatID, "Enter a description for the transaction (max 100 characters), or /skip to leave it empty. Add @YYYY-MM-DD to backdate it or ref:<number> to attach a reference.")
+	}
+}
+
+// processConfirmAmount handles the Yes/No answer for amounts above
+// LARGE_AMOUNT_THRESHOLD or outside the category's range.
+func processConfirmAmount(callback *tgbotapi.CallbackQuery, state *TransactionState) {
+	confirmAmount(callback.Message.Chat.ID, callback.Message.MessageID, state, callback.Data == "amount:yes")
+	if callback.Data == "amount:yes" {
+		answerCallback(callback, "Confirmed")
+	} else {
+		answerCallback(callback, "")
+	}
+}
+
+// confirmAmount applies the answer to the large amount prompt in messageID,
+// whether it came from a button or a reaction.
+func confirmAmount(chatID int64, messageID int, state *TransactionState, confirmed bool) {
+	if !confirmed {
+		state.Step = "ENTER_AMOUNT"
+		if err := editMessage(chatID, messageID, "Okay, enter the transaction amount again."); err != nil {
+			sendMessage(chatID, "Okay, enter the transaction amount again.")
+		}
+		return
+	}
+
+	// promptDescription sends its own message, so a failed edit loses nothing
+	editMessage(chatID, messageID, fmt.Sprintf("Amount confirmed: %s.", formatAmount(state.Amount)))
+	promptDescription(chatID, state)
 }
 
 func processDescription(message *tgbotapi.Message, state *TransactionState) {
-	if len(message.Text) > 100 {
-		sendMessage(message.Chat.ID, "Description too long. Please keep it under 100 characters.")
+	text, date, err := extractDateToken(message.Text)
+	if err != nil {
+		sendMessage(message.Chat.ID, fmt.Sprintf("Invalid date: %v", err))
+		return
+	}
+	text, reference, err := extractReference(text)
+	if err != nil {
+		sendMessage(message.Chat.ID, fmt.Sprintf("Invalid reference: %v", err))
 		return
 	}
+	text, paymentMethod, err := extractPaymentMethod(text)
+	if err != nil {
+		sendMessage(message.Chat.ID, fmt.Sprintf("Invalid payment method: %v", err))
+		return
+	}
+	text, tags := extractTags(text)
+	if len([]rune(text)) > 100 {
+		if getSetting(state.UserID, "desc_overflow", "reject") != "trim" {
+			sendMessage(message.Chat.ID, "Description too long. Please keep it under 100 characters.")
+			return
+		}
+		text = string([]rune(text)[:100])
+		sendMessage(message.Chat.ID, "Description trimmed to 100 characters.")
+	}
 
-	state.Description = message.Text
+	if DESC_REQUIRED && strings.TrimSpace(text) == "" {
+		sendMessage(message.Chat.ID, "Description can't be empty.")
+		return
+	}
 
-	// Get current time in GMT+7
-	currentTime := time.Now().In(time.FixedZone("GMT+7", 7*60*60))
+	state.Description = text
+	state.Tags = tags
+	state.Date = date
+	state.Reference = reference
+	state.PaymentMethod = paymentMethod
+	if promptInferredCategory(message.Chat.ID, state) {
+		return
+	}
+	finishDescription(message.Chat.ID, state)
+}
+
+// saveTransaction inserts the collected transaction and ends the flow.
+func saveTransaction(chatID int64, state *TransactionState) {
+	// Get current time in the configured timezone
+	currentTime := time.Now().In(location)
+	if !state.Date.IsZero() {
+		currentTime = state.Date
+	}
 
-	stmt, err := db.Prepare("INSERT INTO transactions (type, category, amount, description, created_at) VALUES (?, ?, ?, ?, ?)")
+	// On failure the collected state is kept so the save can be retried
+	id, roundUp, err := storeTransaction(chatID, state, currentTime)
 	if err != nil {
-		sendMessage(message.Chat.ID, "Failed to prepare transaction.")
-		log.Printf("Database prepare error: %v", err)
+		log.Printf("Failed to save transaction: %v", err)
+		offerRetrySave(chatID, state, "Failed to save transaction. "+dbErrorHint(err))
 		return
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(state.TransactionType, state.Category, state.Amount, state.Description, currentTime.Format("2006-01-02 15:04:05"))
+	clearState(state)
+	text := "Transaction added successfully! Reply to this message with a new amount to correct it."
+	if state.Pending {
+		text = fmt.Sprintf("Pending transaction #%d added. It won't count in summaries until you /confirm %d.", id, id)
+	}
+	if roundUp > 0 {
+		text += fmt.Sprintf("\nRounded up: %s moved to savings.", formatAmount(roundUp))
+	}
+	if state.TransactionType == "expense" && !state.Pending {
+		text += remainingBudgetNote(chatID, currentTime)
+	}
+	sent := sendMessageWithMenu(chatID, text)
+	rememberConfirmation(chatID, sent.MessageID, id)
+	refreshPin(chatID)
+	sendFollowUp(chatID, state.UserID)
+}
+
+// storeTransaction saves the transaction collected in state along with what
+// follows every new entry: learning its category and the round-up of an
+// expense. It is shared by the /add flow and the HTTP ingest endpoint, and
+// returns the new row's id and the amount rounded up.
+func storeTransaction(chatID int64, state *TransactionState, createdAt time.Time) (int64, float64, error) {
+	description := state.Description
+	if state.Breakdown != "" {
+		description = strings.TrimSpace(description + " (" + state.Breakdown + ")")
+	}
+	description, err := encryptField(description)
+	if err != nil {
+		return 0, 0, fmt.Errorf("encrypting the description: %w", err)
+	}
+	id, err := insertTransaction(chatID, state, createdAt, description)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	recordCategoryChoice(chatID, state.Description, state.Suggested, state.Category)
+	roundUp := 0.0
+	if state.TransactionType == "expense" {
+		if roundUp, err = saveRoundUp(chatID, id, state.Amount, createdAt); err != nil {
+			log.Printf("Failed to save round-up for transaction %d: %v", id, err)
+		}
+	}
+	return id, roundUp, nil
+}
+
+// insertTransaction stores the transaction collected in state, with its
+// description already encrypted, and returns the new row's id.
+func insertTransaction(chatID int64, state *TransactionState, createdAt time.Time, description string) (int64, error) {
+	status := "cleared"
+	if state.Pending {
+		status = "pending"
+	}
+	var latitude, longitude interface{}
+	if state.Location != nil {
+		latitude, longitude = state.Location.Latitude, state.Location.Longitude
+	}
+	result, err := db.Exec("INSERT INTO transactions (type, category, amount, description, created_at, chat_id, status, reference, latitude, longitude, payment_method) VALUES (?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?, ?, NULLIF(?, ''))",
+		state.TransactionType, state.Category, state.Amount, description, createdAt.Format(timeLayout), chatID, status, state.Reference, latitude, longitude, state.PaymentMethod)
+	if err != nil {
+		return 0, err
+	}
+	id, _ := result.LastInsertId()
+	if len(state.Tags) > 0 {
+		saveTags(id, state.Tags)
+	}
+	return id, nil
+}
+
+// sendFollowUp offers the next action once a flow has ended, unless the
+// user turned it off with the follow_up setting.
+func sendFollowUp(chatID int64, userID int64) {
+	if getSetting(userID, "follow_up", strconv.FormatBool(FOLLOW_UP_PROMPT)) == "true" {
+		sendMessage(chatID, "Add another? /add")
+	}
+}
+
+// remainingBudgetNote reports how much of MONTHLY_BUDGET is left after an
+// expense dated at, or "" when no budget is set or at is in another month.
+func remainingBudgetNote(chatID int64, at time.Time) string {
+	now := time.Now().In(location)
+	if MONTHLY_BUDGET <= 0 || at.Year() != now.Year() || at.Month() != now.Month() {
+		return ""
+	}
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, location)
+	_, spent, err := queryTypeTotals(chatID, start, start.AddDate(0, 1, 0), false)
 	if err != nil {
-		sendMessage(message.Chat.ID, "Failed to save transaction.")
-		log.Printf("Database exec error: %v", err)
+		log.Printf("Database query error: %v", err)
+		return ""
+	}
+	remaining := MONTHLY_BUDGET - spent
+	if remaining < 0 {
+		return fmt.Sprintf("\nOver budget this month by %s (budget %s).\n%s", formatAmount(-remaining), formatAmount(MONTHLY_BUDGET), progressBar(spent, MONTHLY_BUDGET))
+	}
+	return fmt.Sprintf("\nRemaining this month: %s of %s budget.\n%s", formatAmount(remaining), formatAmount(MONTHLY_BUDGET), progressBar(spent, MONTHLY_BUDGET))
+}
+
+// offerRetrySave keeps a transaction whose save failed, so a "Retry save"
+// button can insert it again without re-entering anything. /cancel drops it.
+func offerRetrySave(chatID int64, state *TransactionState, text string) {
+	state.Step = "RETRY_SAVE"
+	// /quick saves without registering a flow
+	userStates[stateKey{state.ChatID, state.UserID}] = state
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Retry save", "save:retry"),
+	))
+	sendMessageWithKeyboard(chatID, text, keyboard)
+}
+
+func processRetrySave(callback *tgbotapi.CallbackQuery, state *TransactionState) {
+	if callback.Data != "save:retry" {
+		answerCallback(callback, "")
 		return
 	}
+	editMessage(callback.Message.Chat.ID, callback.Message.MessageID, "Retrying…")
+	answerCallback(callback, "")
+	saveTransaction(callback.Message.Chat.ID, state)
+}
 
-	delete(userStates, state.UserID)
-	sendMessage(message.Chat.ID, "Transaction added successfully!")
+// dbErrorHint turns common SQLite failures into advice for the user.
+func dbErrorHint(err error) string {
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "locked") || strings.Contains(msg, "busy"):
+		return "The database is busy right now; tap Retry save in a moment."
+	case strings.Contains(msg, "full"):
+		return "The server is out of disk space; please free some space and tap Retry save."
+	case strings.Contains(msg, "readonly") || strings.Contains(msg, "read-only"):
+		return "The database is read-only; check the file permissions of DB_PATH."
+	case strings.Contains(msg, "constraint"):
+		return "The data was rejected by the database; try /add again."
+	default:
+		return "Tap Retry save to try again."
+	}
 }
 
+// summaryOptions controls what showMonthSummary includes.
+type summaryOptions struct {
+	detailed       bool // Add the expense breakdown by category
+	includePending bool // Count transactions that are still pending
+	carryover      bool // Open with the balance of every earlier month
+}
 
-func showSummary(chatID int64) {
-	currentMonth := time.Now().UTC().Format("01")
-	rows, err := db.Query("SELECT type, SUM(amount) as total FROM transactions WHERE strftime('%m', created_at) = ? GROUP BY type", currentMonth)
+// userSummaryOptions returns the summary options saved by the user.
+func userSummaryOptions(userID int64) summaryOptions {
+	return summaryOptions{
+		detailed:  getSetting(userID, "summary_format", "compact") == "detailed",
+		carryover: getSetting(userID, "summary_carryover", "false") == "true",
+	}
+}
+
+// showSummary reports the current month. args may be "compact" or
+// "detailed" to override the user's summary_format setting, and
+// "include_pending" to count pending transactions; "carryover" and
+// "no_carryover" override the summary_carryover setting.
+func showSummary(chatID int64, userID int64, args string) {
+	opts := userSummaryOptions(userID)
+	for _, arg := range strings.Fields(strings.ToLower(args)) {
+		switch arg {
+		case "compact", "detailed":
+			opts.detailed = arg == "detailed"
+		case "include_pending":
+			opts.includePending = true
+		case "carryover", "no_carryover":
+			opts.carryover = arg == "carryover"
+		default:
+			sendMessage(chatID, "Usage: /summary [compact|detailed] [include_pending] [carryover|no_carryover]")
+			return
+		}
+	}
+
+	now := time.Now().In(location)
+	text := showMonthSummary(chatID, time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, location), opts)
+	copyReport(chatID, "summary", text)
+}
+
+// showLastMonth summarizes the previous calendar month; AddDate rolls
+// January back to December of the previous year.
+func showLastMonth(chatID int64, userID int64) {
+	now := time.Now().In(location)
+	text := showMonthSummary(chatID, time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, location).AddDate(0, -1, 0), userSummaryOptions(userID))
+	copyReport(chatID, "lastmonth", text)
+}
+
+// showMonthSummary reports totals for the month starting at month and
+// returns the text it sent, or "" when it failed.
+func showMonthSummary(chatID int64, month time.Time, opts summaryOptions) string {
+	incomeTotal, expenseTotal, savingsTotal, err := queryMonthAggregates(chatID, month, opts.includePending)
 	if err != nil {
 		sendMessage(chatID, "Error retrieving transactions.")
 		log.Printf("Database query error: %v", err)
-		return
+		return ""
+	}
+
+	// Savings rows are set aside, so they lower the balance without being spending
+	balance := incomeTotal - expenseTotal - savingsTotal
+	summaryMessage := fmt.Sprintf("Monthly Summary Report for %s:\n\n", month.Format("January 2006"))
+	summaryMessage += fmt.Sprintf("Total Income: %s\nTotal Expense: %s\n", formatAmount(incomeTotal), formatAmount(expenseTotal))
+	if savingsTotal > 0 {
+		summaryMessage += fmt.Sprintf("Round-up Savings: %s\n", formatAmount(savingsTotal))
+	}
+	summaryMessage += fmt.Sprintf("\nBalance: %s", formatAmount(balance))
+	if opts.carryover {
+		opening, err := queryOpeningBalance(chatID, month, opts.includePending)
+		if err != nil {
+			sendMessage(chatID, "Error retrieving transactions.")
+			log.Printf("Database query error: %v", err)
+			return ""
+		}
+		summaryMessage += fmt.Sprintf("\n\nOpening: %s, This month net: %s, Closing: %s",
+			formatAmount(opening), formatAmount(balance), formatAmount(opening+balance))
+	}
+	summaryMessage += monthlyGoalLines(incomeTotal, expenseTotal)
+	if opts.detailed && expenseTotal > 0 {
+		where := "created_at >= ? AND created_at < ?"
+		if !opts.includePending {
+			where += " AND status = 'cleared'"
+		}
+		totals, err := queryCategoryTotals(chatID, where, month.Format(timeLayout), month.AddDate(0, 1, 0).Format(timeLayout))
+		if err != nil {
+			sendMessage(chatID, "Error retrieving transactions.")
+			log.Printf("Database query error: %v", err)
+			return ""
+		}
+		summaryMessage += "\n\nExpenses by category:\n" + formatCategoryBreakdown(totals)
+	}
+	if !opts.includePending {
+		var pending int
+		if err := db.QueryRow("SELECT COUNT(*) FROM transactions WHERE chat_id = ? AND deleted_at IS NULL AND status = 'pending' AND created_at >= ? AND created_at < ?",
+			chatID, month.Format(timeLayout), month.AddDate(0, 1, 0).Format(timeLayout)).Scan(&pending); err != nil {
+			log.Printf("Database query error: %v", err)
+		} else if pending > 0 {
+			summaryMessage += fmt.Sprintf("\n\n%d pending transaction(s) not included; see /summary include_pending.", pending)
+		}
+	}
+	if RETENTION_MONTHS > 0 && month.Before(retentionCutoff(RETENTION_MONTHS)) {
+		summaryMessage += fmt.Sprintf("\n\nNote: transactions older than %d month(s) are deleted automatically, so this month may be incomplete.", RETENTION_MONTHS)
+	}
+	sendLongMessage(chatID, summaryMessage)
+	return summaryMessage
+}
+
+// queryOpeningBalance is the chat's income minus expense for everything
+// created before start.
+func queryOpeningBalance(chatID int64, start time.Time, includePending bool) (float64, error) {
+	var opening sql.NullFloat64
+	err := db.QueryRow("SELECT SUM(CASE WHEN type = 'income' THEN amount ELSE -amount END) FROM transactions WHERE chat_id = ? AND deleted_at IS NULL AND created_at < ? AND (? OR status = 'cleared')",
+		chatID, start.Format(timeLayout), includePending).Scan(&opening)
+	return opening.Float64, err
+}
+
+// queryTypeTotals sums the chat's income and expense created in [start, end),
+// skipping pending transactions unless includePending is set.
+func queryTypeTotals(chatID int64, start, end time.Time, includePending bool) (incomeTotal, expenseTotal float64, err error) {
+	rows, err := db.Query("SELECT type, SUM(amount) as total FROM transactions WHERE chat_id = ? AND deleted_at IS NULL AND created_at >= ? AND created_at < ? AND (? OR status = 'cleared') GROUP BY type",
+		chatID, start.Format(timeLayout), end.Format(timeLayout), includePending)
+	if err != nil {
+		return 0, 0, err
 	}
 	defer rows.Close()
 
-	incomeTotal := 0.0
-	expenseTotal := 0.0
 	for rows.Next() {
 		var transactionType string
 		var total float64
@@ -272,69 +1433,116 @@ func showSummary(chatID int64) {
 	if err = rows.Err(); err != nil {
 		log.Printf("Rows error: %v", err)
 	}
-
-	balance := incomeTotal - expenseTotal
-	summaryMessage := fmt.Sprintf("Monthly Summary Report for %s:\n\n", time.Now().Format("January 2006"))
-	summaryMessage += fmt.Sprintf("Total Income: %.2f\nTotal Expense: %.2f\n\nBalance: %.2f", 
-		incomeTotal, expenseTotal, balance)
-	sendMessage(chatID, summaryMessage)
+	return incomeTotal, expenseTotal, nil
 }
 
 func sendMessage(chatID int64, text string) {
-	msg := tgbotapi.NewMessage(chatID, text)
+	msg := tgbotapi.NewMessage(chatID, takeTimer(chatID).annotate(text))
 	_, err := bot.Send(msg)
 	if err != nil {
 		log.Printf("Error sending message: %v", err)
+		noteSendError(chatID, err)
+	}
+}
+
+// sendLongMessage splits text on line boundaries so it fits within
+// Telegram's message size limit.
+// Timing goes after the last part.
+func sendLongMessage(chatID int64, text string) {
+	for _, part := range splitMessage(takeTimer(chatID).annotate(text)) {
+		sendMessage(chatID, part)
 	}
 }
 
-func sendMessageWithKeyboard(chatID int64, text string, keyboard tgbotapi.InlineKeyboardMarkup) {
+func splitMessage(text string) []string {
+	const limit = 4000
+	var parts []string
+	for len(text) > limit {
+		cut := strings.LastIndex(text[:limit], "\n")
+		if cut <= 0 {
+			cut = limit
+		}
+		parts = append(parts, text[:cut])
+		text = strings.TrimLeft(text[cut:], "\n")
+	}
+	if text != "" {
+		parts = append(parts, text)
+	}
+	return parts
+}
+
+// sendMessageWithKeyboard sends text with inline buttons and returns the
+// sent message, which is empty if sending failed.
+func sendMessageWithKeyboard(chatID int64, text string, keyboard tgbotapi.InlineKeyboardMarkup) tgbotapi.Message {
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyMarkup = keyboard
-	_, err := bot.Send(msg)
+	sent, err := bot.Send(msg)
 	if err != nil {
 		log.Printf("Error sending message with keyboard: %v", err)
 	}
+	return sent
 }
 
-func editMessage(chatID int64, messageID int, text string) {
+// editMessage replaces the text of a sent message. The error is returned
+// so conversation steps can send a fresh message instead, e.g. when the
+// original was deleted.
+func editMessage(chatID int64, messageID int, text string) error {
 	msg := tgbotapi.NewEditMessageText(chatID, messageID, text)
 	_, err := bot.Send(msg)
 	if err != nil {
 		log.Printf("Error editing message: %v", err)
 	}
+	return err
 }
 
-func editMessageWithKeyboard(chatID int64, messageID int, text string, keyboard tgbotapi.InlineKeyboardMarkup) {
+func editMessageWithKeyboard(chatID int64, messageID int, text string, keyboard tgbotapi.InlineKeyboardMarkup) error {
 	msg := tgbotapi.NewEditMessageTextAndMarkup(chatID, messageID, text, keyboard)
 	_, err := bot.Send(msg)
 	if err != nil {
 		log.Printf("Error editing message with keyboard: %v", err)
 	}
+	return err
 }
 
-func get_latest_report(chatID int64) {
-	cmd := exec.Command("python3", "src/g_latest_r.py") // Path to your Python script
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Printf("Error executing Python script: %s", err)
-		sendMessage(chatID, "Failed to execute the report.")
-		return
+// answerCallback acknowledges a button tap so the client stops showing a
+// loading indicator. A non-empty text is shown as a short toast.
+func answerCallback(callback *tgbotapi.CallbackQuery, text string) {
+	if _, err := bot.Request(tgbotapi.NewCallback(callback.ID, text)); err != nil {
+		log.Printf("Error answering callback query: %v", err)
 	}
-
-	sendMessage(chatID, string(output))
 }
 
+func get_latest_report(chatID int64) {
+	runReportScript(chatID, "src/g_latest_r.py") // Path to your Python script
+}
 
 func get_weekly_expense_report(chatID int64) {
-	cmd := exec.Command("python3", "src/g_weekly_e_r.py") // Replace with your Python script path
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Printf("Error executing Python script: %s", err)
-		sendMessage(chatID, "Failed to execute the report.")
+	runReportScript(chatID, "src/g_weekly_e_r.py") // Replace with your Python script path
+}
+
+// reportMu serializes the Python report scripts, which all read the same
+// database and are heavy enough that running them together hurts.
+var reportMu sync.Mutex
+
+// runReportScript runs a report script in the background so the bot keeps
+// answering meanwhile, refusing to start a second one while one runs. The
+// timing, when on, covers the script and lands on its output.
+func runReportScript(chatID int64, script string) {
+	if !reportMu.TryLock() {
+		sendMessage(chatID, "A report is already running, please wait.")
 		return
 	}
+	timer := takeTimer(chatID)
+	go func() {
+		defer reportMu.Unlock()
+		cmd := exec.Command("python3", script)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			log.Printf("Error executing Python script: %s", err)
+			sendMessage(chatID, timer.annotate("Failed to execute the report."))
+			return
+		}
 
-	sendMessage(chatID, string(output))
+		sendMessage(chatID, timer.annotate(string(output)))
+	}()
 }
-
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// quickButtonCommands maps the labels allowed in QUICK_BUTTONS to the
+// command each one runs.
+var quickButtonCommands = map[string]string{
+	"Add":       "add",
+	"Income":    "income",
+	"Expense":   "expense",
+	"Summary":   "summary",
+	"Report":    "get_latest_report",
+	"Weekly":    "get_weekly_expense",
+	"Net worth": "networth",
+	"Settings":  "settings",
+}
+
+// quickButtons holds the labels shown on the reply keyboard, in order.
+var quickButtons = []string{"Add", "Summary"}
+
+func parseQuickButtons(value string) {
+	quickButtons = nil
+	for _, label := range strings.Split(value, ",") {
+		label = strings.TrimSpace(label)
+		if label == "" {
+			continue
+		}
+		if _, ok := quickButtonCommands[label]; !ok {
+			log.Printf("Ignoring unknown quick button %q", label)
+			continue
+		}
+		quickButtons = append(quickButtons, label)
+	}
+}
+
+// quickButtonCommand returns the command for a tapped reply keyboard button.
+func quickButtonCommand(text string) (string, bool) {
+	for _, label := range quickButtons {
+		if text == label {
+			return quickButtonCommands[label], true
+		}
+	}
+	return "", false
+}
+
+// sendMessageWithMenu sends text with the quick-access reply keyboard and
+// returns the sent message, which is empty if sending failed.
+func sendMessageWithMenu(chatID int64, text string) tgbotapi.Message {
+	msg := tgbotapi.NewMessage(chatID, text)
+	if len(quickButtons) > 0 {
+		var row []tgbotapi.KeyboardButton
+		var rows [][]tgbotapi.KeyboardButton
+		for _, label := range quickButtons {
+			row = append(row, tgbotapi.NewKeyboardButton(label))
+			if len(row) == 2 {
+				rows = append(rows, row)
+				row = nil
+			}
+		}
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+		keyboard := tgbotapi.NewReplyKeyboard(rows...)
+		keyboard.ResizeKeyboard = true
+		msg.ReplyMarkup = keyboard
+	} else {
+		msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(false)
+	}
+	sent, err := bot.Send(msg)
+	if err != nil {
+		log.Printf("Error sending message with menu: %v", err)
+	}
+	return sent
+}
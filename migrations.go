@@ -0,0 +1,303 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// migration is one step of the schema. Steps must be idempotent, since
+// databases created before schema_version existed run all of them once.
+type migration struct {
+	version     int
+	description string
+	up          func(conn *sql.DB) error
+}
+
+// migrations is applied in order by runMigrations. Append new steps with
+// the next version number; never edit or reorder released ones.
+var migrations = []migration{
+	{1, "create transactions", func(conn *sql.DB) error {
+		_, err := conn.Exec(`CREATE TABLE IF NOT EXISTS transactions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			type TEXT NOT NULL,
+			category TEXT NOT NULL,
+			amount REAL NOT NULL,
+			description TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`)
+		return err
+	}},
+	{2, "create settings", func(conn *sql.DB) error {
+		// Per-user preferences
+		_, err := conn.Exec(`CREATE TABLE IF NOT EXISTS settings (
+			user_id INTEGER NOT NULL,
+			key TEXT NOT NULL,
+			value TEXT NOT NULL,
+			PRIMARY KEY (user_id, key)
+		)`)
+		return err
+	}},
+	{3, "create tags", func(conn *sql.DB) error {
+		// Tags parsed from #hashtags in descriptions
+		_, err := conn.Exec(`CREATE TABLE IF NOT EXISTS tags (
+			transaction_id INTEGER NOT NULL,
+			tag TEXT NOT NULL,
+			PRIMARY KEY (transaction_id, tag)
+		)`)
+		return err
+	}},
+	{4, "create confirmations", func(conn *sql.DB) error {
+		// Confirmation messages, so replying to one can edit its transaction
+		_, err := conn.Exec(`CREATE TABLE IF NOT EXISTS confirmations (
+			chat_id INTEGER NOT NULL,
+			message_id INTEGER NOT NULL,
+			transaction_id INTEGER NOT NULL,
+			PRIMARY KEY (chat_id, message_id)
+		)`)
+		return err
+	}},
+	{5, "create recurring", func(conn *sql.DB) error {
+		// Recurring templates and the ledger of periods they have run for
+		_, err := conn.Exec(`CREATE TABLE IF NOT EXISTS recurring (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			type TEXT NOT NULL,
+			category TEXT NOT NULL,
+			amount REAL NOT NULL,
+			description TEXT,
+			day_of_month INTEGER NOT NULL
+		)`)
+		if err != nil {
+			return err
+		}
+		_, err = conn.Exec(`CREATE TABLE IF NOT EXISTS recurring_runs (
+			recurring_id INTEGER NOT NULL,
+			period TEXT NOT NULL,
+			ran_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (recurring_id, period)
+		)`)
+		return err
+	}},
+	{6, "create goals", func(conn *sql.DB) error {
+		// Savings goals
+		_, err := conn.Exec(`CREATE TABLE IF NOT EXISTS goals (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			target REAL NOT NULL,
+			deadline TEXT NOT NULL,
+			UNIQUE (chat_id, name)
+		)`)
+		return err
+	}},
+	{7, "create quick_entries", func(conn *sql.DB) error {
+		// Keywords for /quick, each logging a fixed transaction
+		_, err := conn.Exec(`CREATE TABLE IF NOT EXISTS quick_entries (
+			chat_id INTEGER NOT NULL,
+			keyword TEXT NOT NULL,
+			type TEXT NOT NULL,
+			category TEXT NOT NULL,
+			amount REAL NOT NULL,
+			description TEXT,
+			PRIMARY KEY (chat_id, keyword)
+		)`)
+		return err
+	}},
+	{8, "create pins", func(conn *sql.DB) error {
+		// Pinned metrics message of each chat
+		_, err := conn.Exec(`CREATE TABLE IF NOT EXISTS pins (
+			chat_id INTEGER PRIMARY KEY,
+			message_id INTEGER NOT NULL
+		)`)
+		return err
+	}},
+	{9, "create category suggestion tables", func(conn *sql.DB) error {
+		// Words learned from descriptions for category suggestions, and how
+		// the suggestions were answered
+		_, err := conn.Exec(`CREATE TABLE IF NOT EXISTS category_words (
+			chat_id INTEGER NOT NULL,
+			word TEXT NOT NULL,
+			category TEXT NOT NULL,
+			weight REAL NOT NULL,
+			PRIMARY KEY (chat_id, word, category)
+		)`)
+		if err != nil {
+			return err
+		}
+		_, err = conn.Exec(`CREATE TABLE IF NOT EXISTS category_feedback (
+			chat_id INTEGER NOT NULL,
+			suggested TEXT NOT NULL,
+			chosen TEXT NOT NULL,
+			created_at TEXT NOT NULL
+		)`)
+		return err
+	}},
+	{10, "scope transactions and recurring by chat", func(conn *sql.DB) error {
+		// Rows from before group support belong to the allowed user's private chat
+		for _, table := range []string{"transactions", "recurring"} {
+			if _, err := addColumnIfMissing(conn, table, "chat_id", "INTEGER"); err != nil {
+				return err
+			}
+			if _, err := conn.Exec("UPDATE "+table+" SET chat_id = ? WHERE chat_id IS NULL", ALLOWED_USER_ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{11, "add transactions.status", func(conn *sql.DB) error {
+		// Pending transactions are left out of summaries until confirmed
+		_, err := addColumnIfMissing(conn, "transactions", "status", "TEXT NOT NULL DEFAULT 'cleared'")
+		return err
+	}},
+	{12, "add transactions.reference", func(conn *sql.DB) error {
+		// Optional reference such as an invoice number
+		_, err := addColumnIfMissing(conn, "transactions", "reference", "TEXT")
+		return err
+	}},
+	{13, "add transactions.deleted_at", func(conn *sql.DB) error {
+		// Soft-deleted transactions are kept in the trash until purged
+		_, err := addColumnIfMissing(conn, "transactions", "deleted_at", "TEXT")
+		return err
+	}},
+	{14, "add transactions location", func(conn *sql.DB) error {
+		// Optional location the transaction was logged at
+		for _, column := range []string{"latitude", "longitude"} {
+			if _, err := addColumnIfMissing(conn, "transactions", column, "REAL"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{15, "create category_ranges", func(conn *sql.DB) error {
+		// Expected amount range per category; 0 leaves a side open
+		_, err := conn.Exec(`CREATE TABLE IF NOT EXISTS category_ranges (
+			category TEXT PRIMARY KEY,
+			min_amount REAL NOT NULL DEFAULT 0,
+			max_amount REAL NOT NULL DEFAULT 0
+		)`)
+		return err
+	}},
+	{16, "add transactions.payment_method", func(conn *sql.DB) error {
+		// Optional payment method such as cash or card
+		_, err := addColumnIfMissing(conn, "transactions", "payment_method", "TEXT")
+		return err
+	}},
+	{17, "create monthly_aggregates", func(conn *sql.DB) error {
+		// Cached monthly totals, see aggregates.go. The triggers only touch
+		// months listed in monthly_aggregate_months, the rest are built on
+		// first read.
+		statements := []string{
+			`CREATE TABLE IF NOT EXISTS monthly_aggregates (
+			chat_id INTEGER NOT NULL,
+			month TEXT NOT NULL,
+			type TEXT NOT NULL,
+			status TEXT NOT NULL,
+			total REAL NOT NULL DEFAULT 0,
+			count INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (chat_id, month, type, status)
+		)`,
+			`CREATE TABLE IF NOT EXISTS monthly_aggregate_months (
+			chat_id INTEGER NOT NULL,
+			month TEXT NOT NULL,
+			built_at TEXT NOT NULL,
+			PRIMARY KEY (chat_id, month)
+		)`,
+			`CREATE TRIGGER IF NOT EXISTS monthly_aggregates_insert AFTER INSERT ON transactions
+			WHEN NEW.deleted_at IS NULL BEGIN
+				INSERT OR IGNORE INTO monthly_aggregates (chat_id, month, type, status)
+					SELECT chat_id, month, NEW.type, NEW.status FROM monthly_aggregate_months
+					WHERE chat_id = NEW.chat_id AND month = strftime('%Y-%m', NEW.created_at);
+				UPDATE monthly_aggregates SET total = total + NEW.amount, count = count + 1
+					WHERE chat_id = NEW.chat_id AND month = strftime('%Y-%m', NEW.created_at) AND type = NEW.type AND status = NEW.status;
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS monthly_aggregates_delete AFTER DELETE ON transactions
+			WHEN OLD.deleted_at IS NULL BEGIN
+				UPDATE monthly_aggregates SET total = total - OLD.amount, count = count - 1
+					WHERE chat_id = OLD.chat_id AND month = strftime('%Y-%m', OLD.created_at) AND type = OLD.type AND status = OLD.status;
+			END`,
+			// An update is handled as removing the old row and adding the new one
+			`CREATE TRIGGER IF NOT EXISTS monthly_aggregates_update_old AFTER UPDATE ON transactions
+			WHEN OLD.deleted_at IS NULL BEGIN
+				UPDATE monthly_aggregates SET total = total - OLD.amount, count = count - 1
+					WHERE chat_id = OLD.chat_id AND month = strftime('%Y-%m', OLD.created_at) AND type = OLD.type AND status = OLD.status;
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS monthly_aggregates_update_new AFTER UPDATE ON transactions
+			WHEN NEW.deleted_at IS NULL BEGIN
+				INSERT OR IGNORE INTO monthly_aggregates (chat_id, month, type, status)
+					SELECT chat_id, month, NEW.type, NEW.status FROM monthly_aggregate_months
+					WHERE chat_id = NEW.chat_id AND month = strftime('%Y-%m', NEW.created_at);
+				UPDATE monthly_aggregates SET total = total + NEW.amount, count = count + 1
+					WHERE chat_id = NEW.chat_id AND month = strftime('%Y-%m', NEW.created_at) AND type = NEW.type AND status = NEW.status;
+			END`,
+		}
+		for _, statement := range statements {
+			if _, err := conn.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{18, "create audit_log", func(conn *sql.DB) error {
+		// Sensitive commands and their confirmations, see confirm.go
+		_, err := conn.Exec(`CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			action TEXT NOT NULL,
+			detail TEXT,
+			created_at TEXT NOT NULL
+		)`)
+		return err
+	}},
+	{19, "add transactions.round_up_of", func(conn *sql.DB) error {
+		// Links a round-up savings row to its expense so it follows edits.
+		// Older rows are linked from their "Round-up of #N" description
+		// where it isn't encrypted.
+		if _, err := addColumnIfMissing(conn, "transactions", "round_up_of", "INTEGER"); err != nil {
+			return err
+		}
+		_, err := conn.Exec(`UPDATE transactions SET round_up_of = CAST(substr(description, 14) AS INTEGER)
+			WHERE type = 'savings' AND category = 'Round-up' AND round_up_of IS NULL AND description LIKE 'Round-up of #%'`)
+		return err
+	}},
+}
+
+// schemaVersion returns the highest migration recorded in conn.
+func schemaVersion(conn *sql.DB) (int, error) {
+	var version sql.NullInt64
+	err := conn.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version)
+	return int(version.Int64), err
+}
+
+// runMigrations applies every migration newer than the recorded schema
+// version, recording each one as it succeeds.
+func runMigrations(conn *sql.DB) error {
+	_, err := conn.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	current, err := schemaVersion(conn)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := m.up(conn); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+		}
+		if _, err := conn.Exec("INSERT INTO schema_version (version, description, applied_at) VALUES (?, ?, ?)",
+			m.version, m.description, time.Now().In(location).Format(timeLayout)); err != nil {
+			return err
+		}
+		log.Printf("Applied migration %d: %s", m.version, m.description)
+	}
+	return nil
+}
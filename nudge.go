@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// lastInteractionDay caches the day last stored by noteInteraction, so the
+// setting is written at most once a day.
+var lastInteractionDay string
+
+// noteInteraction records that the owner used the bot today.
+func noteInteraction() {
+	today := time.Now().In(location).Format("2006-01-02")
+	if today == lastInteractionDay {
+		return
+	}
+	if err := setSetting(globalSettingsUser, "last_interaction", today); err != nil {
+		log.Printf("Failed to save last interaction: %v", err)
+		return
+	}
+	lastInteractionDay = today
+}
+
+// runNudges sends the owner a daily reminder at DAILY_NUDGE_HOUR when
+// nothing was logged that day. After DIGEST_AFTER_DAYS days without any
+// interaction the reminders are coalesced into one weekly digest until
+// the owner is back.
+func runNudges() error {
+	if DAILY_NUDGE_HOUR < 0 {
+		return nil
+	}
+	now := time.Now().In(location)
+	today := now.Format("2006-01-02")
+	if now.Hour() < DAILY_NUDGE_HOUR || getSetting(globalSettingsUser, "last_nudge", "") == today {
+		return nil
+	}
+	if err := setSetting(globalSettingsUser, "last_nudge", today); err != nil {
+		return err
+	}
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	idle := 0
+	if last, err := time.ParseInLocation("2006-01-02", getSetting(globalSettingsUser, "last_interaction", today), location); err == nil {
+		idle = int(midnight.Sub(last).Hours() / 24)
+	}
+	if DIGEST_AFTER_DAYS > 0 && idle >= DIGEST_AFTER_DAYS {
+		return sendWeeklyDigest(midnight)
+	}
+
+	var logged int
+	if err := db.QueryRow("SELECT COUNT(*) FROM transactions WHERE chat_id = ? AND deleted_at IS NULL AND created_at >= ?",
+		ALLOWED_USER_ID, midnight.Format(timeLayout)).Scan(&logged); err != nil {
+		return err
+	}
+	if logged == 0 {
+		sendScheduledMessage(ALLOWED_USER_ID, "Nothing logged today yet. Anything to /add?")
+	}
+	return nil
+}
+
+// sendWeeklyDigest replaces the daily reminders while the owner is away,
+// at most once every seven days.
+func sendWeeklyDigest(today time.Time) error {
+	if last, err := time.ParseInLocation("2006-01-02", getSetting(globalSettingsUser, "last_digest", ""), location); err == nil && today.Sub(last) < 7*24*time.Hour {
+		return nil
+	}
+	if err := setSetting(globalSettingsUser, "last_digest", today.Format("2006-01-02")); err != nil {
+		return err
+	}
+
+	start := today.AddDate(0, 0, -7)
+	income, expense, err := queryTypeTotals(ALLOWED_USER_ID, start, today, false)
+	if err != nil {
+		return err
+	}
+	sendScheduledMessage(ALLOWED_USER_ID, fmt.Sprintf("Weekly digest (%s to %s):\nIncome: %s\nExpense: %s\n\nDaily reminders are paused until you use the bot again.",
+		start.Format("2 Jan"), today.AddDate(0, 0, -1).Format("2 Jan"), formatAmount(income), formatAmount(expense)))
+	return nil
+}
+
+// runIncomeAlert reminds the owner once a month when no income has been
+// logged this month by the end of INCOME_ALERT_DAY. The day is capped at
+// 28 so the check always falls inside the same month.
+func runIncomeAlert() error {
+	if INCOME_ALERT_DAY == 0 {
+		return nil
+	}
+	now := time.Now().In(location)
+	month := now.Format("2006-01")
+	if now.Day() <= INCOME_ALERT_DAY || getSetting(globalSettingsUser, "last_income_alert", "") == month {
+		return nil
+	}
+
+	// Pending income counts too, it was logged even if not confirmed yet
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, location)
+	var logged bool
+	if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM transactions WHERE chat_id = ? AND deleted_at IS NULL AND type = 'income' AND created_at >= ?)",
+		ALLOWED_USER_ID, start.Format(timeLayout)).Scan(&logged); err != nil {
+		return err
+	}
+	if err := setSetting(globalSettingsUser, "last_income_alert", month); err != nil {
+		return err
+	}
+	if !logged {
+		sendScheduledMessage(ALLOWED_USER_ID, INCOME_ALERT_MESSAGE)
+	}
+	return nil
+}
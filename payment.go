@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// matchPaymentMethod returns the configured spelling of name, or "" when
+// it isn't one of PAYMENT_METHODS.
+func matchPaymentMethod(name string) string {
+	for _, method := range paymentMethods {
+		if strings.EqualFold(method, strings.TrimSpace(name)) {
+			return method
+		}
+	}
+	return ""
+}
+
+// extractPaymentMethod removes a "pay:<method>" token from text, so fast
+// entries can set the method without the extra step.
+func extractPaymentMethod(text string) (string, string, error) {
+	fields := strings.Fields(text)
+	method := ""
+	kept := fields[:0]
+	for _, field := range fields {
+		if len(field) < 4 || !strings.EqualFold(field[:4], "pay:") {
+			kept = append(kept, field)
+			continue
+		}
+		if method != "" {
+			return "", "", fmt.Errorf("only one pay: is allowed")
+		}
+		method = matchPaymentMethod(field[4:])
+		if method == "" {
+			return "", "", fmt.Errorf("unknown payment method %q, use one of: %s", field[4:], strings.Join(paymentMethods, ", "))
+		}
+	}
+	if method == "" {
+		return text, "", nil
+	}
+	return strings.Join(kept, " "), method, nil
+}
+
+// promptPaymentMethod asks how the transaction was paid, when the user
+// turned on ask_payment_method and no pay: token was given.
+func promptPaymentMethod(chatID int64, state *TransactionState) {
+	state.Step = "SELECT_PAYMENT_METHOD"
+	// Buttons carry the index, method names may not fit in callback data
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, method := range paymentMethods {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(method, "pay:"+strconv.Itoa(i))))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Skip", "pay:skip")))
+	sendMessageWithKeyboard(chatID, "How did you pay?", tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+func processPaymentMethod(callback *tgbotapi.CallbackQuery, state *TransactionState) {
+	chatID := callback.Message.Chat.ID
+	choice := strings.TrimPrefix(callback.Data, "pay:")
+	if choice == "skip" {
+		editMessage(chatID, callback.Message.MessageID, "Payment method skipped.")
+		answerCallback(callback, "")
+		promptLocation(chatID, state)
+		return
+	}
+	i, err := strconv.Atoi(choice)
+	if err != nil || i < 0 || i >= len(paymentMethods) {
+		answerCallback(callback, "Unknown payment method")
+		return
+	}
+	state.PaymentMethod = paymentMethods[i]
+	editMessage(chatID, callback.Message.MessageID, "Paid by "+state.PaymentMethod+".")
+	answerCallback(callback, "")
+	promptLocation(chatID, state)
+}
+
+// processTypedPaymentMethod accepts a method name typed instead of tapped.
+func processTypedPaymentMethod(message *tgbotapi.Message, state *TransactionState) {
+	method := matchPaymentMethod(message.Text)
+	if method == "" {
+		sendMessage(message.Chat.ID, nonTextPrompt(state))
+		return
+	}
+	state.PaymentMethod = method
+	promptLocation(message.Chat.ID, state)
+}
+
+// showByMethod totals a period's cleared expenses per payment method.
+func showByMethod(chatID int64, args string) {
+	start, end, label, err := parsePeriod(chatID, args)
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("Invalid period: %v", err))
+		return
+	}
+
+	rows, err := db.Query(`SELECT COALESCE(payment_method, ''), SUM(amount) AS total, COUNT(*) FROM transactions
+		WHERE chat_id = ? AND deleted_at IS NULL AND type = 'expense' AND status = 'cleared' AND created_at >= ? AND created_at < ?
+		GROUP BY COALESCE(payment_method, '') ORDER BY total DESC`,
+		chatID, start.Format(timeLayout), end.Format(timeLayout))
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	text := fmt.Sprintf("Expenses by payment method (%s):\n\n", label)
+	sum, found := 0.0, false
+	for rows.Next() {
+		var method string
+		var total float64
+		var count int
+		if err := rows.Scan(&method, &total, &count); err != nil {
+			log.Printf("Row scan error: %v", err)
+			continue
+		}
+		if method == "" {
+			method = "(not set)"
+		}
+		text += fmt.Sprintf("%s: %s (%d)\n", method, formatAmount(total), count)
+		sum += total
+		found = true
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("Rows error: %v", err)
+	}
+	if !found {
+		sendMessage(chatID, fmt.Sprintf("No expenses for %s.", label))
+		return
+	}
+	text += fmt.Sprintf("\nTotal: %s", formatAmount(sum))
+	sendMessage(chatID, text)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// pinText is the body of the pinned metrics message.
+func pinText(chatID int64) (string, error) {
+	now := time.Now().In(location)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, location)
+	incomeTotal, expenseTotal, err := queryTypeTotals(chatID, month, month.AddDate(0, 1, 0), false)
+	if err != nil {
+		return "", err
+	}
+	savingsTotal, err := querySavingsTotal(chatID, month, month.AddDate(0, 1, 0))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("📌 %s\nIncome: %s\nExpense: %s\nBalance: %s\n\nUpdated %s",
+		month.Format("January 2006"), formatAmount(incomeTotal), formatAmount(expenseTotal),
+		formatAmount(incomeTotal-expenseTotal-savingsTotal), now.Format("2 Jan 15:04")), nil
+}
+
+// pinMetrics sends the current month's totals and pins the message, so
+// refreshPin can keep it up to date after every transaction.
+func pinMetrics(chatID int64) {
+	text, err := pinText(chatID)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	sent, err := bot.Send(tgbotapi.NewMessage(chatID, text))
+	if err != nil {
+		log.Printf("Error sending message: %v", err)
+		return
+	}
+	pin := tgbotapi.PinChatMessageConfig{ChatID: chatID, MessageID: sent.MessageID, DisableNotification: true}
+	if _, err := bot.Request(pin); err != nil {
+		sendMessage(chatID, "I couldn't pin the message. In groups I need the permission to pin messages.")
+		log.Printf("Error pinning message: %v", err)
+		return
+	}
+
+	if _, err := db.Exec(`INSERT INTO pins (chat_id, message_id) VALUES (?, ?)
+		ON CONFLICT(chat_id) DO UPDATE SET message_id = excluded.message_id`, chatID, sent.MessageID); err != nil {
+		log.Printf("Database exec error: %v", err)
+	}
+}
+
+// refreshPin updates the chat's pinned metrics message, if it has one. A
+// pin whose message was deleted is forgotten until /pin is used again.
+func refreshPin(chatID int64) {
+	var messageID int
+	err := db.QueryRow("SELECT message_id FROM pins WHERE chat_id = ?", chatID).Scan(&messageID)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+		return
+	}
+
+	text, err := pinText(chatID)
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	_, err = bot.Send(tgbotapi.NewEditMessageText(chatID, messageID, text))
+	if err == nil || strings.Contains(err.Error(), "message is not modified") {
+		return
+	}
+	log.Printf("Pinned message %d in chat %d can't be updated, forgetting it: %v", messageID, chatID, err)
+	if _, err := db.Exec("DELETE FROM pins WHERE chat_id = ?", chatID); err != nil {
+		log.Printf("Database exec error: %v", err)
+	}
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// qrVersion describes one QR code size at error correction level L.
+type qrVersion struct {
+	codewords int   // Data and error correction codewords together
+	ecc       int   // Error correction codewords per block
+	blocks    int   // Number of blocks
+	align     []int // Alignment pattern centre coordinates
+}
+
+// qrVersions lists versions 1 to 10, which hold up to 271 bytes. That is
+// plenty for a summary and keeps the code easy to scan from a screen.
+var qrVersions = []qrVersion{
+	{26, 7, 1, nil},
+	{44, 10, 1, []int{6, 18}},
+	{70, 15, 1, []int{6, 22}},
+	{100, 20, 1, []int{6, 26}},
+	{134, 26, 1, []int{6, 30}},
+	{172, 18, 2, []int{6, 34}},
+	{196, 20, 2, []int{6, 22, 38}},
+	{242, 24, 2, []int{6, 24, 42}},
+	{292, 30, 2, []int{6, 26, 46}},
+	{346, 18, 4, []int{6, 28, 50}},
+}
+
+// qrMaxBytes is the largest payload encodeQR accepts.
+const qrMaxBytes = 271
+
+// qrCode is a square grid of modules; true is dark.
+type qrCode struct {
+	size     int
+	modules  [][]bool
+	function [][]bool // Finder, timing, alignment and format modules
+}
+
+// encodeQR encodes data in byte mode at error correction level L, using
+// the smallest version that fits.
+func encodeQR(data []byte) (*qrCode, error) {
+	for i, v := range qrVersions {
+		version := i + 1
+		countBits := 8
+		if version >= 10 {
+			countBits = 16
+		}
+		capacity := (v.codewords - v.ecc*v.blocks) * 8
+		if 4+countBits+len(data)*8 > capacity {
+			continue
+		}
+
+		var bits qrBits
+		bits.append(0x4, 4)
+		bits.append(len(data), countBits)
+		for _, b := range data {
+			bits.append(int(b), 8)
+		}
+		// Terminator, byte alignment and alternating pad bytes
+		bits.append(0, min(4, capacity-len(bits)))
+		bits.append(0, (8-len(bits)%8)%8)
+		for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+			bits.append(pad, 8)
+		}
+
+		qr := newQRCode(version, v)
+		qr.placeData(addErrorCorrection(bits.bytes(), v))
+		qr.applyBestMask()
+		return qr, nil
+	}
+	return nil, fmt.Errorf("payload of %d bytes exceeds the %d byte limit", len(data), qrMaxBytes)
+}
+
+type qrBits []bool
+
+func (b *qrBits) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*b = append(*b, (value>>i)&1 == 1)
+	}
+}
+
+func (b qrBits) bytes() []byte {
+	out := make([]byte, len(b)/8)
+	for i, bit := range b {
+		if bit {
+			out[i/8] |= 1 << (7 - i%8)
+		}
+	}
+	return out
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMultiply(x, y int) int {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= ((y >> i) & 1) * x
+	}
+	return z
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of degree,
+// highest coefficient first without the leading 1.
+func rsDivisor(degree int) []int {
+	result := make([]int, degree)
+	result[degree-1] = 1
+	root := 1
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+func rsRemainder(data []byte, divisor []int) []byte {
+	result := make([]int, len(divisor))
+	for _, b := range data {
+		factor := int(b) ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMultiply(divisor[i], factor)
+		}
+	}
+	out := make([]byte, len(result))
+	for i, r := range result {
+		out[i] = byte(r)
+	}
+	return out
+}
+
+// addErrorCorrection splits data into blocks, appends each block's error
+// correction codewords and interleaves the blocks.
+func addErrorCorrection(data []byte, v qrVersion) []byte {
+	shortBlocks := v.blocks - v.codewords%v.blocks
+	shortLen := v.codewords / v.blocks
+	divisor := rsDivisor(v.ecc)
+
+	var blocks [][]byte
+	k := 0
+	for i := 0; i < v.blocks; i++ {
+		n := shortLen - v.ecc
+		if i >= shortBlocks {
+			n++
+		}
+		block := append([]byte(nil), data[k:k+n]...)
+		k += n
+		ecc := rsRemainder(block, divisor)
+		if i < shortBlocks {
+			// Placeholder so every block has the same length
+			block = append(block, 0)
+		}
+		blocks = append(blocks, append(block, ecc...))
+	}
+
+	var result []byte
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			if i != shortLen-v.ecc || j >= shortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+func newQRCode(version int, v qrVersion) *qrCode {
+	size := version*4 + 17
+	qr := &qrCode{size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for y := range qr.modules {
+		qr.modules[y] = make([]bool, size)
+		qr.function[y] = make([]bool, size)
+	}
+
+	for i := 0; i < size; i++ {
+		qr.setFunction(6, i, i%2 == 0)
+		qr.setFunction(i, 6, i%2 == 0)
+	}
+	qr.drawFinder(3, 3)
+	qr.drawFinder(size-4, 3)
+	qr.drawFinder(3, size-4)
+	last := len(v.align) - 1
+	for i, x := range v.align {
+		for j, y := range v.align {
+			// Skip the three corners taken by finder patterns
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			qr.drawAlignment(x, y)
+		}
+	}
+	// Reserve the format areas; the real bits are drawn with the mask
+	qr.drawFormat(0)
+	if version >= 7 {
+		qr.drawVersion(version)
+	}
+	return qr
+}
+
+func (qr *qrCode) setFunction(x, y int, dark bool) {
+	qr.modules[y][x] = dark
+	qr.function[y][x] = true
+}
+
+// drawFinder draws a finder pattern and its separator centred on (x, y).
+func (qr *qrCode) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || xx >= qr.size || yy < 0 || yy >= qr.size {
+				continue
+			}
+			d := max(abs(dx), abs(dy))
+			qr.setFunction(xx, yy, d != 2 && d != 4)
+		}
+	}
+}
+
+func (qr *qrCode) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			qr.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// drawFormat draws both copies of the format information for level L and
+// the given mask, plus the dark module.
+func (qr *qrCode) drawFormat(mask int) {
+	data := 1<<3 | mask // Level L is 01
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>i)&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		qr.setFunction(8, i, bit(i))
+	}
+	qr.setFunction(8, 7, bit(6))
+	qr.setFunction(8, 8, bit(7))
+	qr.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		qr.setFunction(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		qr.setFunction(qr.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		qr.setFunction(8, qr.size-15+i, bit(i))
+	}
+	qr.setFunction(8, qr.size-8, true)
+}
+
+func (qr *qrCode) drawVersion(version int) {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := version<<12 | rem
+	for i := 0; i < 18; i++ {
+		dark := (bits>>i)&1 == 1
+		a, b := qr.size-11+i%3, i/3
+		qr.setFunction(a, b, dark)
+		qr.setFunction(b, a, dark)
+	}
+}
+
+// placeData fills the non-function modules in the zigzag order, two
+// columns at a time from the bottom right.
+func (qr *qrCode) placeData(data []byte) {
+	i := 0
+	for right := qr.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < qr.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = qr.size - 1 - vert
+				}
+				if !qr.function[y][x] && i < len(data)*8 {
+					qr.modules[y][x] = (data[i/8]>>(7-i%8))&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+func qrMask(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+// toggleMask applies a mask to the data modules; applying it twice undoes it.
+func (qr *qrCode) toggleMask(mask int) {
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			if !qr.function[y][x] && qrMask(mask, x, y) {
+				qr.modules[y][x] = !qr.modules[y][x]
+			}
+		}
+	}
+}
+
+// applyBestMask tries every mask and keeps the one with the lowest penalty.
+func (qr *qrCode) applyBestMask() {
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		qr.toggleMask(mask)
+		qr.drawFormat(mask)
+		if p := qr.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		qr.toggleMask(mask)
+	}
+	qr.toggleMask(best)
+	qr.drawFormat(best)
+}
+
+// penalty scores how hard the code is to scan: long runs, 2x2 blocks,
+// finder-like patterns and an unbalanced dark ratio all add to it.
+func (qr *qrCode) penalty() int {
+	penalty := 0
+	finderLike := []bool{true, false, true, true, true, false, true}
+	for pass := 0; pass < 2; pass++ {
+		for a := 0; a < qr.size; a++ {
+			line := make([]bool, qr.size)
+			for b := range line {
+				if pass == 0 {
+					line[b] = qr.modules[a][b]
+				} else {
+					line[b] = qr.modules[b][a]
+				}
+			}
+			run := 1
+			for b := 1; b <= qr.size; b++ {
+				if b < qr.size && line[b] == line[b-1] {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += run - 2
+				}
+				run = 1
+			}
+			for b := 0; b+len(finderLike) <= qr.size; b++ {
+				match := true
+				for k, dark := range finderLike {
+					if line[b+k] != dark {
+						match = false
+						break
+					}
+				}
+				if match && (lightRun(line, b-4, b) || lightRun(line, b+7, b+11)) {
+					penalty += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			if qr.modules[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 {
+				c := qr.modules[y][x]
+				if qr.modules[y-1][x] == c && qr.modules[y][x-1] == c && qr.modules[y-1][x-1] == c {
+					penalty += 3
+				}
+			}
+		}
+	}
+	total := qr.size * qr.size
+	penalty += abs(dark*20-total*10) / total * 10
+	return penalty
+}
+
+// lightRun reports whether line[from:to] is all light, treating modules
+// outside the code as light.
+func lightRun(line []bool, from, to int) bool {
+	for i := from; i < to; i++ {
+		if i >= 0 && i < len(line) && line[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// renderQR draws the code as a PNG with scale pixels per module and the
+// standard four module quiet zone.
+func renderQR(qr *qrCode, scale int) ([]byte, error) {
+	const border = 4
+	side := (qr.size + 2*border) * scale
+	img := image.NewGray(image.Rect(0, 0, side, side))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			if !qr.modules[y][x] {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetGray((x+border)*scale+dx, (y+border)*scale+dy, color.Gray{})
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// compactSummary is a period's summary short enough for a QR code: the
+// totals first, then as many of the largest categories as fit.
+func compactSummary(chatID int64, start, end time.Time, label string) (string, error) {
+	income, expense, err := queryTypeTotals(chatID, start, end, false)
+	if err != nil {
+		return "", err
+	}
+	savings, err := querySavingsTotal(chatID, start, end)
+	if err != nil {
+		return "", err
+	}
+	totals, err := queryCategoryTotals(chatID, "created_at >= ? AND created_at < ? AND status = 'cleared'",
+		start.Format(timeLayout), end.Format(timeLayout))
+	if err != nil {
+		return "", err
+	}
+
+	text := fmt.Sprintf("Summary %s\nIncome %s\nExpense %s\nBalance %s", label,
+		formatAmount(income), formatAmount(expense), formatAmount(income-expense-savings))
+	for _, ct := range totals {
+		line := fmt.Sprintf("\n%s %s", ct.Category, formatAmount(ct.Total))
+		if len(text)+len(line) > qrMaxBytes {
+			break
+		}
+		text += line
+	}
+	return text, nil
+}
+
+// sendSummaryQR sends a period's summary as a QR code photo, to move it
+// to another device by scanning the screen.
+func sendSummaryQR(chatID int64, args string) {
+	start, end, label, err := parsePeriod(chatID, args)
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("%v\nUsage: /summary_qr [month|year|all|YYYY-MM]", err))
+		return
+	}
+	text, err := compactSummary(chatID, start, end, label)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	qr, err := encodeQR([]byte(text))
+	var picture []byte
+	if err == nil {
+		picture, err = renderQR(qr, 8)
+	}
+	if err != nil {
+		sendMessage(chatID, "Failed to render the QR code.")
+		log.Printf("QR error: %v", err)
+		return
+	}
+
+	photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{Name: "summary.png", Bytes: picture})
+	photo.Caption = strings.SplitN(text, "\n", 2)[0]
+	if _, err := bot.Send(photo); err != nil {
+		log.Printf("Error sending photo: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// queryRowLimit caps the rows /query returns.
+	queryRowLimit = 50
+	// queryTimeout stops runaway queries.
+	queryTimeout = 10 * time.Second
+	// queryCellWidth truncates long values so the table stays readable.
+	queryCellWidth = 30
+)
+
+// profilePaths holds the file of every profile, for read-only access.
+var profilePaths = make(map[string]string)
+
+// activeProfilePath returns the file of the active database.
+func activeProfilePath() string {
+	for name, conn := range profiles {
+		if conn == db {
+			return profilePaths[name]
+		}
+	}
+	return DB_PATH
+}
+
+// validateQuery accepts a single SELECT (or WITH ... SELECT) statement.
+func validateQuery(query string) error {
+	query = strings.TrimSuffix(strings.TrimSpace(query), ";")
+	lower := strings.ToLower(query)
+	if !strings.HasPrefix(lower, "select") && !strings.HasPrefix(lower, "with") {
+		return fmt.Errorf("only SELECT statements are allowed")
+	}
+	if strings.Contains(query, ";") {
+		return fmt.Errorf("only one statement is allowed")
+	}
+	return nil
+}
+
+// runReadOnlyQuery runs the owner's SELECT against a read-only connection
+// to the active database and replies with the rows as a table. The data
+// of every chat is visible, so only ALLOWED_USER_ID may use it.
+func runReadOnlyQuery(chatID int64, userID int64, query string) {
+	if userID != ALLOWED_USER_ID {
+		sendMessage(chatID, "Only the bot owner can use /query.")
+		return
+	}
+	query = strings.TrimSuffix(strings.TrimSpace(query), ";")
+	if query == "" {
+		sendMessage(chatID, fmt.Sprintf("Usage: /query <SELECT ...>\nAt most %d rows are returned.", queryRowLimit))
+		return
+	}
+	if err := validateQuery(query); err != nil {
+		sendMessage(chatID, fmt.Sprintf("Query rejected: %v.", err))
+		return
+	}
+
+	// mode=ro and query_only both refuse writes, whatever the statement is
+	conn, err := sql.Open("sqlite", "file:"+activeProfilePath()+"?mode=ro&_pragma=query_only(1)")
+	if err != nil {
+		sendMessage(chatID, "Failed to open the database read-only.")
+		log.Printf("Database open error: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("Query failed: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("Query failed: %v", err))
+		return
+	}
+	table := [][]string{columns}
+	truncated := false
+	for rows.Next() {
+		if len(table) > queryRowLimit {
+			truncated = true
+			break
+		}
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			sendMessage(chatID, fmt.Sprintf("Query failed: %v", err))
+			return
+		}
+		row := make([]string, len(columns))
+		for i, v := range values {
+			row[i] = formatQueryValue(v)
+		}
+		table = append(table, row)
+	}
+	if err := rows.Err(); err != nil {
+		sendMessage(chatID, fmt.Sprintf("Query failed: %v", err))
+		return
+	}
+
+	summary := fmt.Sprintf("%d row(s)", len(table)-1)
+	if truncated {
+		summary = fmt.Sprintf("First %d rows", queryRowLimit)
+	}
+	for _, part := range splitMessage(formatQueryTable(table)) {
+		msg := tgbotapi.NewMessage(chatID, "<pre>"+html.EscapeString(part)+"</pre>")
+		msg.ParseMode = tgbotapi.ModeHTML
+		if _, err := bot.Send(msg); err != nil {
+			log.Printf("Error sending message: %v", err)
+		}
+	}
+	sendMessage(chatID, summary)
+}
+
+func formatQueryValue(v interface{}) string {
+	var s string
+	switch v := v.(type) {
+	case nil:
+		s = "NULL"
+	case []byte:
+		s = string(v)
+	case float64:
+		s = fmt.Sprintf("%g", v)
+	default:
+		s = fmt.Sprint(v)
+	}
+	s = strings.ReplaceAll(s, "\n", " ")
+	if utf8.RuneCountInString(s) > queryCellWidth {
+		s = string([]rune(s)[:queryCellWidth-1]) + "…"
+	}
+	return s
+}
+
+// formatQueryTable pads every column to its widest value.
+func formatQueryTable(table [][]string) string {
+	widths := make([]int, len(table[0]))
+	for _, row := range table {
+		for i, cell := range row {
+			if n := utf8.RuneCountInString(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	var sb strings.Builder
+	for r, row := range table {
+		for i, cell := range row {
+			if i > 0 {
+				sb.WriteString(" | ")
+			}
+			sb.WriteString(cell + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)))
+		}
+		sb.WriteString("\n")
+		if r == 0 {
+			for i, w := range widths {
+				if i > 0 {
+					sb.WriteString("-+-")
+				}
+				sb.WriteString(strings.Repeat("-", w))
+			}
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// logQuickEntry saves the transaction configured for a /quick keyword
+// without any prompts.
+func logQuickEntry(chatID int64, userID int64, args string) {
+	keyword := strings.ToLower(strings.TrimSpace(args))
+	if keyword == "" {
+		sendMessage(chatID, "Usage: /quick <keyword>. See /quick_list for the configured entries.")
+		return
+	}
+	// saveTransaction ends the flow of the state it saves
+	if _, exists := userStates[stateKey{chatID, userID}]; exists {
+		sendMessage(chatID, "Please finish the current transaction first.")
+		return
+	}
+
+	state := &TransactionState{ChatID: chatID, UserID: userID}
+	var description string
+	err := db.QueryRow("SELECT type, category, amount, COALESCE(description, '') FROM quick_entries WHERE chat_id = ? AND keyword = ?",
+		chatID, keyword).Scan(&state.TransactionType, &state.Category, &state.Amount, &description)
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("No quick entry named %s. See /quick_list.", keyword))
+		return
+	}
+	if description, err = decryptField(description); err != nil {
+		log.Printf("Decryption error: %v", err)
+	}
+	state.Description, state.Tags = extractTags(description)
+	saveTransaction(chatID, state)
+}
+
+func addQuickEntry(chatID int64, args string) {
+	cmd := newCommandArgs(args, "/quick_add <keyword> <income|expense> <category> <amount> [description]")
+	keyword := strings.ToLower(cmd.next())
+	transactionType := strings.ToLower(cmd.next())
+	category := cmd.category()
+	amountArg := cmd.next()
+	rest := cmd.rest()
+	if !cmd.ok(chatID) {
+		return
+	}
+
+	if transactionType != "income" && transactionType != "expense" {
+		sendMessage(chatID, "Type must be income or expense.")
+		return
+	}
+	amount, err := parseAmount(amountArg)
+	if err != nil || amount <= 0 || amount > maxAmount {
+		sendMessage(chatID, invalidAmountMessage())
+		return
+	}
+	description, err := encryptField(rest)
+	if err != nil {
+		sendMessage(chatID, "Failed to encrypt the description.")
+		log.Printf("Encryption error: %v", err)
+		return
+	}
+
+	_, err = db.Exec(`INSERT INTO quick_entries (chat_id, keyword, type, category, amount, description) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (chat_id, keyword) DO UPDATE SET type = excluded.type, category = excluded.category,
+		amount = excluded.amount, description = excluded.description`,
+		chatID, keyword, transactionType, category, amount, description)
+	if err != nil {
+		sendMessage(chatID, "Failed to save the quick entry.")
+		log.Printf("Database exec error: %v", err)
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("Quick entry saved: /quick %s logs %s %s %s.", keyword, transactionType, category, formatAmount(amount)))
+}
+
+func deleteQuickEntry(chatID int64, args string) {
+	keyword := strings.ToLower(strings.TrimSpace(args))
+	if keyword == "" {
+		sendMessage(chatID, "Usage: /quick_delete <keyword>")
+		return
+	}
+	result, err := db.Exec("DELETE FROM quick_entries WHERE chat_id = ? AND keyword = ?", chatID, keyword)
+	if err != nil {
+		sendMessage(chatID, "Failed to delete the quick entry.")
+		log.Printf("Database exec error: %v", err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		sendMessage(chatID, fmt.Sprintf("No quick entry named %s.", keyword))
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("Quick entry %s deleted.", keyword))
+}
+
+func showQuickEntries(chatID int64) {
+	rows, err := db.Query("SELECT keyword, type, category, amount, COALESCE(description, '') FROM quick_entries WHERE chat_id = ? ORDER BY keyword", chatID)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving quick entries.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	text := "Quick entries:\n\n"
+	count := 0
+	for rows.Next() {
+		var keyword, transactionType, category, description string
+		var amount float64
+		if err := rows.Scan(&keyword, &transactionType, &category, &amount, &description); err != nil {
+			log.Printf("Row scan error: %v", err)
+			continue
+		}
+		text += fmt.Sprintf("/quick %s: %s %s %s", keyword, transactionType, category, formatAmount(amount))
+		if description, _ = decryptField(description); description != "" {
+			text += " — " + description
+		}
+		text += "\n"
+		count++
+	}
+	if count == 0 {
+		sendMessage(chatID, "No quick entries. Add one with /quick_add <keyword> <type> <category> <amount> [description].")
+		return
+	}
+	sendLongMessage(chatID, text)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// categoryRange is the expected amount range of a category. A zero bound
+// is open.
+type categoryRange struct {
+	min, max float64
+}
+
+func (r categoryRange) contains(amount float64) bool {
+	return (r.min == 0 || amount >= r.min) && (r.max == 0 || amount <= r.max)
+}
+
+func (r categoryRange) String() string {
+	switch {
+	case r.min == 0:
+		return "up to " + formatAmount(r.max)
+	case r.max == 0:
+		return "from " + formatAmount(r.min)
+	default:
+		return formatAmount(r.min) + "–" + formatAmount(r.max)
+	}
+}
+
+// queryCategoryRange returns the range configured for category, if any.
+func queryCategoryRange(category string) (categoryRange, bool, error) {
+	var r categoryRange
+	err := db.QueryRow("SELECT min_amount, max_amount FROM category_ranges WHERE category = ?", category).Scan(&r.min, &r.max)
+	if err == sql.ErrNoRows {
+		return r, false, nil
+	}
+	return r, err == nil, err
+}
+
+// parseRangeBound reads one bound of /category_range, where "-" leaves
+// the side open.
+func parseRangeBound(field string) (float64, error) {
+	if field == "-" {
+		return 0, nil
+	}
+	amount, err := parseAmount(field)
+	if err != nil || amount <= 0 || amount > maxAmount {
+		return 0, fmt.Errorf("%q is not a valid amount", field)
+	}
+	return amount, nil
+}
+
+// handleCategoryRange lists, sets or removes the expected amount range of
+// categories. Amounts outside a range need confirmation at entry.
+func handleCategoryRange(chatID int64, args string) {
+	if strings.TrimSpace(args) == "" {
+		showCategoryRanges(chatID)
+		return
+	}
+
+	cmd := newCommandArgs(args, "/category_range <category> <min|-> <max|-> or /category_range <category> off")
+	category := cmd.category()
+	first := cmd.next()
+	if strings.EqualFold(first, "off") {
+		if !cmd.ok(chatID) {
+			return
+		}
+		if _, err := db.Exec("DELETE FROM category_ranges WHERE category = ?", category); err != nil {
+			sendMessage(chatID, "Failed to remove the range.")
+			log.Printf("Database exec error: %v", err)
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("%s no longer has an expected range.", category))
+		return
+	}
+	second := cmd.next()
+	if !cmd.ok(chatID) {
+		return
+	}
+
+	var r categoryRange
+	var err error
+	if r.min, err = parseRangeBound(first); err == nil {
+		r.max, err = parseRangeBound(second)
+	}
+	if err == nil && r.min == 0 && r.max == 0 {
+		err = fmt.Errorf("set at least one bound")
+	}
+	if err == nil && r.max != 0 && r.min > r.max {
+		err = fmt.Errorf("the minimum is above the maximum")
+	}
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("Invalid range: %v.", err))
+		return
+	}
+
+	_, err = db.Exec(`INSERT INTO category_ranges (category, min_amount, max_amount) VALUES (?, ?, ?)
+		ON CONFLICT (category) DO UPDATE SET min_amount = excluded.min_amount, max_amount = excluded.max_amount`,
+		category, r.min, r.max)
+	if err != nil {
+		sendMessage(chatID, "Failed to save the range.")
+		log.Printf("Database exec error: %v", err)
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("%s expenses are expected %s.", category, r))
+}
+
+func showCategoryRanges(chatID int64) {
+	rows, err := db.Query("SELECT category, min_amount, max_amount FROM category_ranges ORDER BY category")
+	if err != nil {
+		sendMessage(chatID, "Error retrieving category ranges.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	text := "Expected amount ranges:\n\n"
+	count := 0
+	for rows.Next() {
+		var category string
+		var r categoryRange
+		if err := rows.Scan(&category, &r.min, &r.max); err != nil {
+			log.Printf("Row scan error: %v", err)
+			continue
+		}
+		text += fmt.Sprintf("%s: %s\n", category, r)
+		count++
+	}
+	if count == 0 {
+		sendMessage(chatID, "No category ranges. Set one with /category_range <category> <min|-> <max|->.")
+		return
+	}
+	sendMessage(chatID, text)
+}
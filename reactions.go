@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// incomingUpdate extends tgbotapi.Update with the message_reaction field,
+// which the library predates.
+type incomingUpdate struct {
+	tgbotapi.Update
+	MessageReaction *messageReaction `json:"message_reaction"`
+}
+
+// messageReaction is a change of a user's reactions to a message.
+type messageReaction struct {
+	Chat        tgbotapi.Chat  `json:"chat"`
+	MessageID   int            `json:"message_id"`
+	User        *tgbotapi.User `json:"user"`
+	NewReaction []struct {
+		Type  string `json:"type"`
+		Emoji string `json:"emoji"`
+	} `json:"new_reaction"`
+}
+
+// pollUpdates long-polls getUpdates like bot.GetUpdatesChan, but decodes
+// into incomingUpdate and asks for reactions as well.
+func pollUpdates(config tgbotapi.UpdateConfig) <-chan incomingUpdate {
+	config.AllowedUpdates = []string{"message", "callback_query", "message_reaction"}
+	ch := make(chan incomingUpdate, 100)
+
+	go func() {
+		for {
+			resp, err := bot.Request(config)
+			var updates []incomingUpdate
+			if err == nil {
+				err = json.Unmarshal(resp.Result, &updates)
+			}
+			if err != nil {
+				log.Printf("Failed to get updates, retrying in 3 seconds: %v", err)
+				time.Sleep(3 * time.Second)
+				continue
+			}
+
+			for _, update := range updates {
+				if update.UpdateID >= config.Offset {
+					config.Offset = update.UpdateID + 1
+					ch <- update
+				}
+			}
+		}
+	}()
+
+	return ch
+}
+
+// handleReaction treats 👍 and 👎 on a large amount prompt like its Yes
+// and No buttons. The buttons stay the main path; other reactions and
+// messages are ignored.
+func handleReaction(reaction *messageReaction) {
+	if reaction.User == nil || !isAuthorized(reaction.Chat.ID, reaction.User.ID) {
+		return
+	}
+	state, exists := userStates[stateKey{reaction.Chat.ID, reaction.User.ID}]
+	if !exists || state.Step != "CONFIRM_AMOUNT" || state.PromptMessageID != reaction.MessageID {
+		return
+	}
+
+	for _, r := range reaction.NewReaction {
+		if r.Type != "emoji" {
+			continue
+		}
+		switch r.Emoji {
+		case "👍":
+			confirmAmount(reaction.Chat.ID, reaction.MessageID, state, true)
+			return
+		case "👎":
+			confirmAmount(reaction.Chat.ID, reaction.MessageID, state, false)
+			return
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type recurringTemplate struct {
+	ID          int64
+	ChatID      int64
+	Type        string
+	Category    string
+	Amount      float64
+	Description string
+	Day         int
+}
+
+// queryRecurring lists the chat's templates, or every chat's when chatID is 0.
+func queryRecurring(conn *sql.DB, chatID int64) ([]recurringTemplate, error) {
+	rows, err := conn.Query(`SELECT id, chat_id, type, category, amount, COALESCE(description, ''), day_of_month FROM recurring
+		WHERE ? = 0 OR chat_id = ? ORDER BY day_of_month, id`, chatID, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []recurringTemplate
+	for rows.Next() {
+		var r recurringTemplate
+		if err := rows.Scan(&r.ID, &r.ChatID, &r.Type, &r.Category, &r.Amount, &r.Description, &r.Day); err != nil {
+			return nil, err
+		}
+		templates = append(templates, r)
+	}
+	return templates, rows.Err()
+}
+
+// dueDay clamps a template's day to the length of the given month, so a
+// day 31 entry still fires at the end of February.
+func dueDay(day int, month time.Time) int {
+	last := time.Date(month.Year(), month.Month()+1, 0, 0, 0, 0, 0, location).Day()
+	if day > last {
+		return last
+	}
+	return day
+}
+
+// runRecurring inserts every template that is due this month and hasn't
+// run yet. The recurring_runs ledger has one row per template and period;
+// claiming that row and inserting the transaction happen in one database
+// transaction, so restarts or overlapping runs can't insert twice.
+func runRecurring(profile string, conn *sql.DB) error {
+	templates, err := queryRecurring(conn, 0)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().In(location)
+	period := now.Format("2006-01")
+	for _, r := range templates {
+		day := dueDay(r.Day, now)
+		if now.Day() < day {
+			continue
+		}
+		createdAt := time.Date(now.Year(), now.Month(), day, 0, 0, 0, 0, location)
+
+		inserted, err := runRecurringOnce(conn, r, period, createdAt)
+		if err != nil {
+			log.Printf("Recurring #%d failed: %v", r.ID, err)
+			continue
+		}
+		if inserted {
+			log.Printf("Recurring #%d inserted for %s in profile %s", r.ID, period, profile)
+			description, _ := decryptField(r.Description)
+			sendScheduledMessage(r.ChatID, fmt.Sprintf("Recurring %s logged (%s): %s %s %s",
+				r.Type, profile, r.Category, formatAmount(r.Amount), description))
+		}
+	}
+	return nil
+}
+
+func runRecurringOnce(conn *sql.DB, r recurringTemplate, period string, createdAt time.Time) (bool, error) {
+	tx, err := conn.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT OR IGNORE INTO recurring_runs (recurring_id, period, ran_at) VALUES (?, ?, ?)",
+		r.ID, period, time.Now().In(location).Format(timeLayout))
+	if err != nil {
+		return false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		// Already ran this period
+		return false, nil
+	}
+
+	// Descriptions are stored in templates exactly as they go into transactions
+	if _, err := tx.Exec("INSERT INTO transactions (type, category, amount, description, created_at, chat_id) VALUES (?, ?, ?, ?, ?, ?)",
+		r.Type, r.Category, r.Amount, r.Description, createdAt.Format(timeLayout), r.ChatID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func showRecurring(chatID int64) {
+	templates, err := queryRecurring(db, chatID)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving recurring transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	if len(templates) == 0 {
+		sendMessage(chatID, "No recurring transactions. Add one with /recurring_add <type> <category> <amount> <day> [description].")
+		return
+	}
+
+	text := "Recurring transactions:\n\n"
+	for _, r := range templates {
+		description, err := decryptField(r.Description)
+		if err != nil {
+			log.Printf("Decryption error: %v", err)
+		}
+		text += fmt.Sprintf("#%d day %d: %s %s %s", r.ID, r.Day, r.Type, r.Category, formatAmount(r.Amount))
+		if description != "" {
+			text += " — " + description
+		}
+		text += "\n"
+	}
+	sendLongMessage(chatID, text)
+}
+
+func addRecurring(chatID int64, args string) {
+	cmd := newCommandArgs(args, "/recurring_add <income|expense> <category> <amount> <day 1-31> [description]")
+	transactionType := strings.ToLower(cmd.next())
+	category := cmd.category()
+	amountArg, dayArg := cmd.next(), cmd.next()
+	rest := cmd.rest()
+	if !cmd.ok(chatID) {
+		return
+	}
+
+	if transactionType != "income" && transactionType != "expense" {
+		sendMessage(chatID, "Type must be income or expense.")
+		return
+	}
+	amount, err := parseAmount(amountArg)
+	if err != nil || amount <= 0 || amount > maxAmount {
+		sendMessage(chatID, "Invalid amount. Please enter a positive number.")
+		return
+	}
+	day, err := strconv.Atoi(dayArg)
+	if err != nil || day < 1 || day > 31 {
+		sendMessage(chatID, "Day must be a number from 1 to 31.")
+		return
+	}
+	description, err := encryptField(rest)
+	if err != nil {
+		sendMessage(chatID, "Failed to encrypt the description.")
+		log.Printf("Encryption error: %v", err)
+		return
+	}
+
+	r := recurringTemplate{ChatID: chatID, Type: transactionType, Category: category, Amount: amount, Description: description, Day: day}
+	text, err := saveRecurring(r)
+	if err != nil {
+		sendMessage(chatID, "Failed to save recurring transaction.")
+		log.Printf("Database exec error: %v", err)
+		return
+	}
+	sendMessage(chatID, text)
+}
+
+// saveRecurring inserts a template whose description is already encrypted
+// and returns the confirmation to show.
+func saveRecurring(r recurringTemplate) (string, error) {
+	result, err := db.Exec("INSERT INTO recurring (type, category, amount, description, day_of_month, chat_id) VALUES (?, ?, ?, ?, ?, ?)",
+		r.Type, r.Category, r.Amount, r.Description, r.Day, r.ChatID)
+	if err != nil {
+		return "", err
+	}
+	id, _ := result.LastInsertId()
+
+	// Don't back-fill the current month if its day has already passed
+	now := time.Now().In(location)
+	note := ""
+	if now.Day() >= dueDay(r.Day, now) {
+		if _, err := db.Exec("INSERT OR IGNORE INTO recurring_runs (recurring_id, period, ran_at) VALUES (?, ?, ?)",
+			id, now.Format("2006-01"), now.Format(timeLayout)); err != nil {
+			log.Printf("Database exec error: %v", err)
+		}
+		note = " It starts next month."
+	}
+	return fmt.Sprintf("Recurring #%d saved: %s %s %s on day %d of each month.%s",
+		id, r.Type, r.Category, formatAmount(r.Amount), r.Day, note), nil
+}
+
+func deleteRecurring(chatID int64, args string) {
+	cmd := newCommandArgs(args, "/recurring_delete <id>")
+	id := cmd.id()
+	if !cmd.ok(chatID) {
+		return
+	}
+	result, err := db.Exec("DELETE FROM recurring WHERE id = ? AND chat_id = ?", id, chatID)
+	if err != nil {
+		sendMessage(chatID, "Failed to delete recurring transaction.")
+		log.Printf("Database exec error: %v", err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		sendMessage(chatID, fmt.Sprintf("Recurring #%d not found.", id))
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("Recurring #%d deleted.", id))
+}
+
+// upcomingDays is how far ahead /upcoming looks.
+const upcomingDays = 30
+
+// showUpcoming lists the recurring transactions due in the next
+// upcomingDays days, in date order.
+func showUpcoming(chatID int64) {
+	templates, err := queryRecurring(db, chatID)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving recurring transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+
+	now := time.Now().In(location)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	until := today.AddDate(0, 0, upcomingDays)
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, location)
+
+	type occurrence struct {
+		date time.Time
+		r    recurringTemplate
+	}
+	var upcoming []occurrence
+	for _, r := range templates {
+		var ran bool
+		err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM recurring_runs WHERE recurring_id = ? AND period = ?)",
+			r.ID, thisMonth.Format("2006-01")).Scan(&ran)
+		if err != nil {
+			sendMessage(chatID, "Error retrieving recurring transactions.")
+			log.Printf("Database query error: %v", err)
+			return
+		}
+		for i := 0; i < 3; i++ {
+			month := thisMonth.AddDate(0, i, 0)
+			due := time.Date(month.Year(), month.Month(), dueDay(r.Day, month), 0, 0, 0, 0, location)
+			if due.Before(today) || (i == 0 && ran) || due.After(until) {
+				continue
+			}
+			upcoming = append(upcoming, occurrence{due, r})
+		}
+	}
+	if len(upcoming) == 0 {
+		sendMessage(chatID, fmt.Sprintf("No recurring transactions due in the next %d days.", upcomingDays))
+		return
+	}
+	sort.SliceStable(upcoming, func(i, j int) bool { return upcoming[i].date.Before(upcoming[j].date) })
+
+	income, expense := 0.0, 0.0
+	text := fmt.Sprintf("Due in the next %d days:\n\n", upcomingDays)
+	for _, o := range upcoming {
+		text += fmt.Sprintf("%s #%d %s %s %s", o.date.Format("Mon 2 Jan"), o.r.ID, o.r.Type, o.r.Category, formatAmount(o.r.Amount))
+		if description, _ := decryptField(o.r.Description); description != "" {
+			text += " — " + description
+		}
+		text += "\n"
+		if o.r.Type == "income" {
+			income += o.r.Amount
+		} else {
+			expense += o.r.Amount
+		}
+	}
+	text += fmt.Sprintf("\nExpected income: %s\nExpected expense: %s", formatAmount(income), formatAmount(expense))
+	sendLongMessage(chatID, text)
+}
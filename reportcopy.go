@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// copyReport forwards the text of a report to every SECONDARY_CHAT_ID when
+// reportType is listed in REPORT_COPY_TYPES. Only reports of the owner's
+// private chat are copied, so members of a group chat can't send data to
+// the secondary chats.
+func copyReport(chatID int64, reportType string, text string) {
+	if text == "" || chatID != ALLOWED_USER_ID || !reportCopyTypes[reportType] {
+		return
+	}
+	for _, target := range secondaryChats {
+		if target == chatID || isChatBlocked(target) {
+			continue
+		}
+		// One unreachable chat shouldn't stop the others
+		if err := sendReportCopy(target, text); err != nil {
+			log.Printf("Failed to copy %s report to chat %d: %v", reportType, target, err)
+			noteSendError(target, err)
+		}
+	}
+}
+
+func sendReportCopy(chatID int64, text string) error {
+	for _, part := range splitMessage(text) {
+		if _, err := bot.Send(tgbotapi.NewMessage(chatID, part)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,826 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// timeLayout is the format created_at values are stored in.
+const timeLayout = "2006-01-02 15:04:05"
+
+// parsePeriod turns a report argument into a [start, end) range in the
+// configured timezone. Accepted values are "month" (the default), "year",
+// "all" and an explicit "YYYY-MM".
+func parsePeriod(chatID int64, arg string) (start, end time.Time, label string, err error) {
+	now := time.Now().In(location)
+	arg = strings.ToLower(strings.TrimSpace(arg))
+
+	switch arg {
+	case "", "month":
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, location)
+		return start, start.AddDate(0, 1, 0), start.Format("January 2006"), nil
+	case "year":
+		start = fiscalYearStart(fiscalYearOf(now))
+		return start, start.AddDate(1, 0, 0), fiscalYearLabel(fiscalYearOf(now)), nil
+	case "all":
+		var first string
+		err = db.QueryRow("SELECT COALESCE(MIN(strftime('%Y-%m-%d %H:%M:%S', created_at)), '') FROM transactions WHERE chat_id = ? AND deleted_at IS NULL", chatID).Scan(&first)
+		if err != nil {
+			return
+		}
+		start = now
+		if first != "" {
+			start, err = time.ParseInLocation(timeLayout, first, location)
+			if err != nil {
+				return
+			}
+		}
+		start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, location)
+		end = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location).AddDate(0, 0, 1)
+		return start, end, "all time", nil
+	}
+
+	month, perr := time.ParseInLocation("2006-01", arg, location)
+	if perr != nil {
+		err = fmt.Errorf("unknown period %q, use month, year, all or YYYY-MM", arg)
+		return
+	}
+	return month, month.AddDate(0, 1, 0), month.Format("January 2006"), nil
+}
+
+// fiscalYearOf names the fiscal year t falls in after the calendar year it
+// ends in, so with FISCAL_YEAR_START_MONTH=7 July 2024 is in FY2025.
+func fiscalYearOf(t time.Time) int {
+	if FISCAL_YEAR_START_MONTH == 1 || int(t.Month()) < FISCAL_YEAR_START_MONTH {
+		return t.Year()
+	}
+	return t.Year() + 1
+}
+
+func fiscalYearStart(year int) time.Time {
+	if FISCAL_YEAR_START_MONTH == 1 {
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, location)
+	}
+	return time.Date(year-1, time.Month(FISCAL_YEAR_START_MONTH), 1, 0, 0, 0, 0, location)
+}
+
+// fiscalYearLabel is the plain year for calendar years, otherwise e.g.
+// "FY2025 (Jul 2024–Jun 2025)".
+func fiscalYearLabel(year int) string {
+	if FISCAL_YEAR_START_MONTH == 1 {
+		return strconv.Itoa(year)
+	}
+	start := fiscalYearStart(year)
+	return fmt.Sprintf("FY%d (%s–%s)", year, start.Format("Jan 2006"), start.AddDate(0, 11, 0).Format("Jan 2006"))
+}
+
+// showYear reports a fiscal year's totals month by month, the current one
+// unless a year is given.
+func showYear(chatID int64, args string) {
+	year := fiscalYearOf(time.Now().In(location))
+	if arg := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(args)), "FY"); arg != "" {
+		var err error
+		year, err = strconv.Atoi(arg)
+		if err != nil || year < 1900 || year > 9999 {
+			sendMessage(chatID, "Usage: /year [YYYY]")
+			return
+		}
+	}
+
+	start := fiscalYearStart(year)
+	text := fmt.Sprintf("Yearly report for %s:\n\n", fiscalYearLabel(year))
+	income, expense, savings := 0.0, 0.0, 0.0
+	for month := start; month.Before(start.AddDate(1, 0, 0)); month = month.AddDate(0, 1, 0) {
+		i, e, s, err := queryMonthAggregates(chatID, month, false)
+		if err != nil {
+			sendMessage(chatID, "Error retrieving transactions.")
+			log.Printf("Database query error: %v", err)
+			return
+		}
+		if i != 0 || e != 0 || s != 0 {
+			text += fmt.Sprintf("%s: +%s / -%s\n", month.Format("Jan 2006"), formatAmount(i), formatAmount(e))
+		}
+		income, expense, savings = income+i, expense+e, savings+s
+	}
+	text += fmt.Sprintf("\nTotal Income: %s\nTotal Expense: %s\n", formatAmount(income), formatAmount(expense))
+	if savings > 0 {
+		text += fmt.Sprintf("Round-up Savings: %s\n", formatAmount(savings))
+	}
+	text += fmt.Sprintf("\nBalance: %s", formatAmount(income-expense-savings))
+	sendMessage(chatID, text)
+}
+
+func showByWeekday(chatID int64, args string) {
+	start, end, label, err := parsePeriod(chatID, args)
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("Invalid period: %v", err))
+		return
+	}
+
+	rows, err := db.Query(`SELECT strftime('%Y-%m-%d %H:%M:%S', created_at), amount FROM transactions
+		WHERE chat_id = ? AND deleted_at IS NULL AND type = 'expense' AND status = 'cleared' AND created_at >= ? AND created_at < ?`,
+		chatID, start.Format(timeLayout), end.Format(timeLayout))
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	var totals [7]float64
+	for rows.Next() {
+		var createdAt string
+		var amount float64
+		if err := rows.Scan(&createdAt, &amount); err != nil {
+			log.Printf("Row scan error: %v", err)
+			continue
+		}
+		t, err := time.ParseInLocation(timeLayout, createdAt, location)
+		if err != nil {
+			log.Printf("Invalid created_at %q: %v", createdAt, err)
+			continue
+		}
+		totals[t.Weekday()] += amount
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("Rows error: %v", err)
+	}
+
+	// Count how often each weekday occurs in the period, up to today
+	last := end
+	if now := time.Now().In(location); now.Before(last) {
+		last = now
+	}
+	var days [7]int
+	for d := start; d.Before(last); d = d.AddDate(0, 0, 1) {
+		days[d.Weekday()]++
+	}
+
+	text := fmt.Sprintf("Expenses by weekday (%s):\n\n", label)
+	// Start the week on Monday
+	for i := 1; i <= 7; i++ {
+		weekday := time.Weekday(i % 7)
+		average := 0.0
+		if days[weekday] > 0 {
+			average = totals[weekday] / float64(days[weekday])
+		}
+		text += fmt.Sprintf("%s: %s (avg %s)\n", weekday.String()[:3], formatAmount(totals[weekday]), formatAmount(average))
+	}
+	sendMessage(chatID, text)
+}
+
+// showByHour buckets a period's expenses by the hour they were logged at
+// and draws a text histogram. Recurring entries are stored at midnight
+// without a real time, so midnight entries are left out. Backdated entries
+// keep the time of day they were typed at and are counted there.
+func showByHour(chatID int64, args string) {
+	start, end, label, err := parsePeriod(chatID, args)
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("Invalid period: %v", err))
+		return
+	}
+
+	rows, err := db.Query(`SELECT strftime('%Y-%m-%d %H:%M:%S', created_at), amount FROM transactions
+		WHERE chat_id = ? AND deleted_at IS NULL AND type = 'expense' AND status = 'cleared' AND created_at >= ? AND created_at < ?`,
+		chatID, start.Format(timeLayout), end.Format(timeLayout))
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	var totals [24]float64
+	var counts [24]int
+	skipped := 0
+	for rows.Next() {
+		var createdAt string
+		var amount float64
+		if err := rows.Scan(&createdAt, &amount); err != nil {
+			log.Printf("Row scan error: %v", err)
+			continue
+		}
+		t, err := time.ParseInLocation(timeLayout, createdAt, location)
+		if err != nil {
+			log.Printf("Invalid created_at %q: %v", createdAt, err)
+			continue
+		}
+		if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 {
+			skipped++
+			continue
+		}
+		totals[t.Hour()] += amount
+		counts[t.Hour()]++
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("Rows error: %v", err)
+	}
+
+	max, logged := 0.0, 0
+	for hour, total := range totals {
+		if total > max {
+			max = total
+		}
+		logged += counts[hour]
+	}
+	if logged == 0 {
+		sendMessage(chatID, fmt.Sprintf("No expenses with a time of day for %s.", label))
+		return
+	}
+
+	text := fmt.Sprintf("Expenses by hour (%s):\n\n", label)
+	for hour, total := range totals {
+		if counts[hour] == 0 {
+			text += fmt.Sprintf("%02d:00\n", hour)
+			continue
+		}
+		text += fmt.Sprintf("%02d:00 %s %s (%d)\n", hour, textBar(total, max, 10), formatAmount(total), counts[hour])
+	}
+	if skipped > 0 {
+		text += fmt.Sprintf("\n%d recurring expense(s) without a time are not shown.", skipped)
+	}
+	sendMessage(chatID, text)
+}
+
+// progressBarWidth is the number of cells in progressBar.
+const progressBarWidth = 10
+
+// progressBar renders value against target as "[█████░░░░░] 50%". The bar
+// stops at full, but the percentage shows any overshoot.
+func progressBar(value, target float64) string {
+	percent := 0.0
+	if target > 0 && value > 0 {
+		percent = value / target * 100
+	}
+	filled := int(percent / 100 * progressBarWidth)
+	if filled > progressBarWidth {
+		filled = progressBarWidth
+	}
+	return fmt.Sprintf("[%s%s] %.0f%%", strings.Repeat("█", filled), strings.Repeat("░", progressBarWidth-filled), percent)
+}
+
+// monthlyGoalLines shows a month's progress toward MONTHLY_BUDGET and
+// MONTHLY_SAVINGS_GOAL, or "" when neither is set.
+func monthlyGoalLines(income, expense float64) string {
+	text := ""
+	if MONTHLY_BUDGET > 0 {
+		text += fmt.Sprintf("\nBudget: %s of %s spent\n%s", formatAmount(expense), formatAmount(MONTHLY_BUDGET), progressBar(expense, MONTHLY_BUDGET))
+	}
+	if MONTHLY_SAVINGS_GOAL > 0 {
+		saved := income - expense
+		text += fmt.Sprintf("\nSavings goal: %s of %s saved\n%s", formatAmount(saved), formatAmount(MONTHLY_SAVINGS_GOAL), progressBar(saved, MONTHLY_SAVINGS_GOAL))
+	}
+	if text == "" {
+		return ""
+	}
+	return "\n" + text
+}
+
+// showMonthlyGoal reports this month's progress toward the monthly budget
+// and savings goal.
+func showMonthlyGoal(chatID int64) {
+	if MONTHLY_BUDGET <= 0 && MONTHLY_SAVINGS_GOAL <= 0 {
+		sendMessage(chatID, "No monthly goal set. Use /set monthly_budget <amount> or /set monthly_savings_goal <amount>.")
+		return
+	}
+	now := time.Now().In(location)
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, location)
+	income, expense, err := queryTypeTotals(chatID, start, start.AddDate(0, 1, 0), false)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("%s so far:%s", start.Format("January 2006"), monthlyGoalLines(income, expense)))
+}
+
+// textBar draws a horizontal bar of width cells scaled against max.
+func textBar(value, max float64, width int) string {
+	if max <= 0 || value <= 0 {
+		return ""
+	}
+	filled := int(value / max * float64(width))
+	if filled == 0 {
+		filled = 1
+	}
+	return strings.Repeat("█", filled)
+}
+
+func showCategoryTrend(chatID int64, args string) {
+	fields := strings.Fields(args)
+	months := 6
+	if len(fields) > 1 {
+		if n, err := strconv.Atoi(fields[len(fields)-1]); err == nil {
+			months = n
+			fields = fields[:len(fields)-1]
+		}
+	}
+	if len(fields) == 0 {
+		sendMessage(chatID, "Usage: /category_trend <name> [months]")
+		return
+	}
+	if months < 1 || months > 36 {
+		sendMessage(chatID, "Number of months must be between 1 and 36.")
+		return
+	}
+	category, ok := findCategory(strings.Join(fields, " "))
+	if !ok {
+		sendMessage(chatID, unknownCategoryMessage(strings.Join(fields, " ")))
+		return
+	}
+
+	now := time.Now().In(location)
+	first := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, location).AddDate(0, -(months - 1), 0)
+
+	rows, err := db.Query(`SELECT strftime('%Y-%m', created_at) AS month, SUM(amount) FROM transactions
+		WHERE chat_id = ? AND deleted_at IS NULL AND status = 'cleared' AND category = ? AND created_at >= ? GROUP BY month`,
+		chatID, category, first.Format(timeLayout))
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	totals := make(map[string]float64)
+	for rows.Next() {
+		var month string
+		var total float64
+		if err := rows.Scan(&month, &total); err != nil {
+			log.Printf("Row scan error: %v", err)
+			continue
+		}
+		totals[month] = total
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("Rows error: %v", err)
+	}
+
+	max := 0.0
+	for _, total := range totals {
+		if total > max {
+			max = total
+		}
+	}
+
+	text := fmt.Sprintf("%s over the last %d month(s):\n\n", category, months)
+	for m := first; !m.After(now); m = m.AddDate(0, 1, 0) {
+		total := totals[m.Format("2006-01")]
+		text += fmt.Sprintf("%s %s %s\n", m.Format("Jan 2006"), textBar(total, max, 10), formatAmount(total))
+	}
+	sendMessage(chatID, text)
+}
+
+// showNetWorth charts the running balance (income minus expense) day by
+// day. Days without transactions carry the previous balance forward.
+func showNetWorth(chatID int64) {
+	rows, err := db.Query(`SELECT date(created_at) AS day,
+		SUM(CASE WHEN type = 'income' THEN amount ELSE -amount END)
+		FROM transactions WHERE chat_id = ? AND deleted_at IS NULL AND status = 'cleared' GROUP BY day ORDER BY day`, chatID)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	net := make(map[string]float64)
+	var first, last string
+	for rows.Next() {
+		var day string
+		var total float64
+		if err := rows.Scan(&day, &total); err != nil {
+			log.Printf("Row scan error: %v", err)
+			continue
+		}
+		net[day] = total
+		if first == "" {
+			first = day
+		}
+		last = day
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("Rows error: %v", err)
+	}
+	if first == "" {
+		sendMessage(chatID, "No transactions yet.")
+		return
+	}
+
+	start, _ := time.ParseInLocation("2006-01-02", first, location)
+	end, _ := time.ParseInLocation("2006-01-02", last, location)
+	if today := time.Now().In(location); today.After(end) {
+		end = today
+	}
+
+	var balances []float64
+	balance, low, high := 0.0, 0.0, 0.0
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		balance += net[d.Format("2006-01-02")]
+		if len(balances) == 0 || balance < low {
+			low = balance
+		}
+		if len(balances) == 0 || balance > high {
+			high = balance
+		}
+		balances = append(balances, balance)
+	}
+
+	chart, err := renderLineChart(balances)
+	if err != nil {
+		sendMessage(chatID, "Failed to render the chart.")
+		log.Printf("Chart render error: %v", err)
+		return
+	}
+
+	photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{Name: "networth.png", Bytes: chart})
+	photo.Caption = fmt.Sprintf("Running balance since %s\n\nNow: %s\nLowest: %s\nHighest: %s",
+		start.Format("2 Jan 2006"), formatAmount(balance), formatAmount(low), formatAmount(high))
+	if _, err := bot.Send(photo); err != nil {
+		log.Printf("Error sending photo: %v", err)
+	}
+}
+
+type categoryTotal struct {
+	Category string
+	Total    float64
+}
+
+// queryCategoryTotals sums the chat's expenses per category, largest
+// first. The optional where clause is ANDed with the expense filter.
+func queryCategoryTotals(chatID int64, where string, args ...interface{}) ([]categoryTotal, error) {
+	query := "SELECT category, SUM(amount) AS total FROM transactions WHERE chat_id = ? AND deleted_at IS NULL AND type = 'expense'"
+	if where != "" {
+		query += " AND " + where
+	}
+	query += " GROUP BY category ORDER BY total DESC"
+
+	rows, err := db.Query(query, append([]interface{}{chatID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []categoryTotal
+	for rows.Next() {
+		var ct categoryTotal
+		if err := rows.Scan(&ct.Category, &ct.Total); err != nil {
+			return nil, err
+		}
+		totals = append(totals, ct)
+	}
+	return totals, rows.Err()
+}
+
+// formatCategoryBreakdown lists each category's total and share of the whole.
+func formatCategoryBreakdown(totals []categoryTotal) string {
+	sum := 0.0
+	for _, ct := range totals {
+		sum += ct.Total
+	}
+
+	var sb strings.Builder
+	for _, ct := range totals {
+		percent := 0.0
+		if sum > 0 {
+			percent = ct.Total / sum * 100
+		}
+		sb.WriteString(fmt.Sprintf("%s: %s (%.1f%%)\n", ct.Category, formatAmount(ct.Total), percent))
+	}
+	sb.WriteString(fmt.Sprintf("\nTotal: %s", formatAmount(sum)))
+	return sb.String()
+}
+
+// formatCategoryPercentages lists each category's share of the whole
+// without any amounts, so it can be shown on a shared screen.
+func formatCategoryPercentages(totals []categoryTotal) string {
+	sum := 0.0
+	for _, ct := range totals {
+		sum += ct.Total
+	}
+
+	var sb strings.Builder
+	for _, ct := range totals {
+		percent := 0.0
+		if sum > 0 {
+			percent = ct.Total / sum * 100
+		}
+		sb.WriteString(fmt.Sprintf("%s: %.1f%% %s\n", ct.Category, percent, textBar(percent, 100, 20)))
+	}
+	return sb.String()
+}
+
+// showSummaryPie shows the period's expense split by category as
+// percentages only.
+func showSummaryPie(chatID int64, args string) {
+	start, end, label, err := parsePeriod(chatID, args)
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("%v\nUsage: /summary_pie [month|year|all|YYYY-MM]", err))
+		return
+	}
+	totals, err := queryCategoryTotals(chatID, "created_at >= ? AND created_at < ? AND status = 'cleared'",
+		start.Format(timeLayout), end.Format(timeLayout))
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	if len(totals) == 0 {
+		sendMessage(chatID, fmt.Sprintf("No expenses for %s.", label))
+		return
+	}
+	sendLongMessage(chatID, fmt.Sprintf("Expense split for %s:\n\n%s", label, formatCategoryPercentages(totals)))
+}
+
+func showCategoriesAllTime(chatID int64) {
+	totals, err := queryCategoryTotals(chatID, "status = 'cleared'")
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	if len(totals) == 0 {
+		sendMessage(chatID, "No expenses recorded yet.")
+		return
+	}
+	sendLongMessage(chatID, "Lifetime expenses by category:\n\n"+formatCategoryBreakdown(totals))
+}
+
+// showSavingsRate reports 1 - expense/income for a period.
+func showSavingsRate(chatID int64, args string) {
+	start, end, label, err := parsePeriod(chatID, args)
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("%v\nUsage: /savings_rate [month|year|all|YYYY-MM]", err))
+		return
+	}
+	incomeTotal, expenseTotal, err := queryTypeTotals(chatID, start, end, false)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+
+	text := fmt.Sprintf("Savings rate for %s:\n\nIncome: %s\nExpense: %s\n\n", label, formatAmount(incomeTotal), formatAmount(expenseTotal))
+	if incomeTotal == 0 {
+		sendMessage(chatID, text+"No income recorded, so there is no savings rate.")
+		return
+	}
+	rate := (1 - expenseTotal/incomeTotal) * 100
+	text += fmt.Sprintf("Savings rate: %.1f%% (%s)", rate, savingsRateNote(rate))
+	sendMessage(chatID, text)
+}
+
+func savingsRateNote(rate float64) string {
+	switch {
+	case rate >= 20:
+		return "Great!"
+	case rate >= 10:
+		return "Good"
+	case rate >= 0:
+		return "Tight, try to save a bit more"
+	default:
+		return "Spending more than you earn"
+	}
+}
+
+// outlierFactor is how many times its category average a transaction must
+// be to be flagged by /category_avg.
+const outlierFactor = 2
+
+// showCategoryAverages lists the average and count of expenses per
+// category for a period, then flags transactions far above their average.
+func showCategoryAverages(chatID int64, args string) {
+	start, end, label, err := parsePeriod(chatID, args)
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("%v\nUsage: /category_avg [month|year|all|YYYY-MM]", err))
+		return
+	}
+
+	transactions, err := queryTransactions(
+		"SELECT "+transactionColumns+" FROM transactions WHERE chat_id = ? AND deleted_at IS NULL AND type = 'expense' AND status = 'cleared' AND created_at >= ? AND created_at < ? ORDER BY created_at",
+		chatID, start.Format(timeLayout), end.Format(timeLayout),
+	)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	if len(transactions) == 0 {
+		sendMessage(chatID, fmt.Sprintf("No expenses for %s.", label))
+		return
+	}
+
+	type categoryAverage struct {
+		category string
+		total    float64
+		count    int
+	}
+	byCategory := make(map[string]*categoryAverage)
+	var averages []*categoryAverage
+	for _, t := range transactions {
+		ca, ok := byCategory[t.Category]
+		if !ok {
+			ca = &categoryAverage{category: t.Category}
+			byCategory[t.Category] = ca
+			averages = append(averages, ca)
+		}
+		ca.total += t.Amount
+		ca.count++
+	}
+	sort.Slice(averages, func(i, j int) bool {
+		return averages[i].total/float64(averages[i].count) > averages[j].total/float64(averages[j].count)
+	})
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Average expense per category, %s:\n\n", label))
+	for _, ca := range averages {
+		sb.WriteString(fmt.Sprintf("%s: %s avg over %d\n", ca.category, formatAmount(ca.total/float64(ca.count)), ca.count))
+	}
+
+	var outliers []Transaction
+	for _, t := range transactions {
+		ca := byCategory[t.Category]
+		if ca.count > 1 && t.Amount > outlierFactor*ca.total/float64(ca.count) {
+			outliers = append(outliers, t)
+		}
+	}
+	if len(outliers) > 0 {
+		sb.WriteString(fmt.Sprintf("\nMore than %dx their category average:\n", outlierFactor))
+		sb.WriteString(formatTransactionList(outliers))
+	}
+	sendLongMessage(chatID, sb.String())
+}
+
+// monthTotals is what /diff compares for each month.
+type monthTotals struct {
+	income, expense, balance float64
+	categories               map[string]float64
+}
+
+func queryMonthTotals(chatID int64, month time.Time) (monthTotals, error) {
+	end := month.AddDate(0, 1, 0)
+	income, expense, err := queryTypeTotals(chatID, month, end, false)
+	if err != nil {
+		return monthTotals{}, err
+	}
+	savings, err := querySavingsTotal(chatID, month, end)
+	if err != nil {
+		return monthTotals{}, err
+	}
+	totals, err := queryCategoryTotals(chatID, "created_at >= ? AND created_at < ? AND status = 'cleared'",
+		month.Format(timeLayout), end.Format(timeLayout))
+	if err != nil {
+		return monthTotals{}, err
+	}
+
+	m := monthTotals{income: income, expense: expense, balance: income - expense - savings, categories: make(map[string]float64)}
+	for _, ct := range totals {
+		m.categories[ct.Category] = ct.Total
+	}
+	return m, nil
+}
+
+// formatDelta renders a change with an explicit sign.
+func formatDelta(delta float64) string {
+	if delta >= 0 {
+		return "+" + formatAmount(delta)
+	}
+	return "-" + formatAmount(-delta)
+}
+
+// showMonthDiff compares two months side by side, listing the category
+// expense changes from the first month to the second, largest first.
+// Months without transactions count as zeros.
+func showMonthDiff(chatID int64, args string) {
+	cmd := newCommandArgs(args, "/diff <YYYY-MM> <YYYY-MM>")
+	from, to := cmd.month(), cmd.month()
+	if !cmd.ok(chatID) {
+		return
+	}
+	if from.Equal(to) {
+		sendMessage(chatID, "Choose two different months to compare.")
+		return
+	}
+
+	a, err := queryMonthTotals(chatID, from)
+	if err == nil {
+		var b monthTotals
+		if b, err = queryMonthTotals(chatID, to); err == nil {
+			sendLongMessage(chatID, formatMonthDiff(from, to, a, b))
+			return
+		}
+	}
+	sendMessage(chatID, "Error retrieving transactions.")
+	log.Printf("Database query error: %v", err)
+}
+
+func formatMonthDiff(from, to time.Time, a, b monthTotals) string {
+	fromLabel, toLabel := from.Format("Jan 2006"), to.Format("Jan 2006")
+	text := fmt.Sprintf("%s vs %s:\n\n", fromLabel, toLabel)
+	text += fmt.Sprintf("Income: %s → %s (%s)\n", formatAmount(a.income), formatAmount(b.income), formatDelta(b.income-a.income))
+	text += fmt.Sprintf("Expense: %s → %s (%s)\n", formatAmount(a.expense), formatAmount(b.expense), formatDelta(b.expense-a.expense))
+	text += fmt.Sprintf("Balance: %s → %s (%s)\n", formatAmount(a.balance), formatAmount(b.balance), formatDelta(b.balance-a.balance))
+
+	type categoryDelta struct {
+		category string
+		from, to float64
+	}
+	var deltas []categoryDelta
+	for category, total := range a.categories {
+		deltas = append(deltas, categoryDelta{category, total, b.categories[category]})
+	}
+	for category, total := range b.categories {
+		if _, seen := a.categories[category]; !seen {
+			deltas = append(deltas, categoryDelta{category, 0, total})
+		}
+	}
+	if len(deltas) == 0 {
+		return text + "\nNo expenses in either month."
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		di, dj := math.Abs(deltas[i].to-deltas[i].from), math.Abs(deltas[j].to-deltas[j].from)
+		if di != dj {
+			return di > dj
+		}
+		return deltas[i].category < deltas[j].category
+	})
+
+	text += "\nExpenses by category:\n"
+	for _, d := range deltas {
+		text += fmt.Sprintf("%s: %s → %s (%s)\n", d.category, formatAmount(d.from), formatAmount(d.to), formatDelta(d.to-d.from))
+	}
+	return text
+}
+
+// topMerchants is how many descriptions /summary_by_description lists.
+const topMerchants = 10
+
+// normalizeDescription groups descriptions that differ only in case or
+// spacing, such as "Starbucks " and "starbucks".
+func normalizeDescription(description string) string {
+	return strings.ToLower(strings.Join(strings.Fields(description), " "))
+}
+
+// showSummaryByDescription lists the descriptions, usually merchants, with
+// the highest expense total for a period.
+func showSummaryByDescription(chatID int64, args string) {
+	start, end, label, err := parsePeriod(chatID, args)
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("%v\nUsage: /summary_by_description [month|year|all|YYYY-MM]", err))
+		return
+	}
+
+	// Descriptions may be encrypted, so they are grouped here rather than in SQL
+	transactions, err := queryTransactions(
+		"SELECT "+transactionColumns+" FROM transactions WHERE chat_id = ? AND deleted_at IS NULL AND type = 'expense' AND status = 'cleared' AND created_at >= ? AND created_at < ? ORDER BY created_at",
+		chatID, start.Format(timeLayout), end.Format(timeLayout),
+	)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+
+	type merchantTotal struct {
+		name  string
+		total float64
+		count int
+	}
+	byName := make(map[string]*merchantTotal)
+	var merchants []*merchantTotal
+	for _, t := range transactions {
+		key := normalizeDescription(t.Description)
+		if key == "" {
+			continue
+		}
+		m, ok := byName[key]
+		if !ok {
+			m = &merchantTotal{name: strings.Join(strings.Fields(t.Description), " ")}
+			byName[key] = m
+			merchants = append(merchants, m)
+		}
+		m.total += t.Amount
+		m.count++
+	}
+	if len(merchants) == 0 {
+		sendMessage(chatID, fmt.Sprintf("No described expenses for %s.", label))
+		return
+	}
+	sort.SliceStable(merchants, func(i, j int) bool { return merchants[i].total > merchants[j].total })
+	if len(merchants) > topMerchants {
+		merchants = merchants[:topMerchants]
+	}
+
+	text := fmt.Sprintf("Top %d by description for %s:\n\n", len(merchants), label)
+	for i, m := range merchants {
+		text += fmt.Sprintf("%d. %s: %s (%d×)\n", i+1, m.name, formatAmount(m.total), m.count)
+	}
+	sendLongMessage(chatID, text)
+}
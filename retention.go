@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// retentionCutoff returns the start of the month that is months before
+// the current one; anything created earlier is eligible for purging.
+func retentionCutoff(months int) time.Time {
+	now := time.Now().In(location)
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, location).AddDate(0, -months, 0)
+}
+
+// purgeBefore deletes the chat's transactions created before cutoff, or
+// every chat's when chatID is 0, along with their tags and confirmation links.
+func purgeBefore(conn *sql.DB, chatID int64, cutoff time.Time) (int64, error) {
+	return deleteTransactions(conn, "created_at < ? AND (? = 0 OR chat_id = ?)", cutoff.Format(timeLayout), chatID, chatID)
+}
+
+// deleteTransactions permanently removes the transactions matching where,
+// along with their tags and confirmation links.
+func deleteTransactions(conn *sql.DB, where string, args ...interface{}) (int64, error) {
+	tx, err := conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	old := "SELECT id FROM transactions WHERE " + where
+	if _, err := tx.Exec("DELETE FROM tags WHERE transaction_id IN ("+old+")", args...); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM confirmations WHERE transaction_id IN ("+old+")", args...); err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec("DELETE FROM transactions WHERE "+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	return n, tx.Commit()
+}
+
+// runRetention is the scheduled job enforcing RETENTION_MONTHS.
+func runRetention(profile string, conn *sql.DB) error {
+	if RETENTION_MONTHS <= 0 {
+		return nil
+	}
+	n, err := purgeBefore(conn, 0, retentionCutoff(RETENTION_MONTHS))
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("Retention removed %d transaction(s) older than %d month(s) from profile %s", n, RETENTION_MONTHS, profile)
+	}
+	return nil
+}
+
+func confirmPurge(chatID int64, args string) {
+	months := RETENTION_MONTHS
+	if arg := strings.TrimSpace(args); arg != "" {
+		var err error
+		months, err = strconv.Atoi(arg)
+		if err != nil || months < 1 {
+			sendMessage(chatID, "Usage: /purge <months>")
+			return
+		}
+	}
+	if months <= 0 {
+		sendMessage(chatID, "Usage: /purge <months> (or set RETENTION_MONTHS)")
+		return
+	}
+
+	cutoff := retentionCutoff(months)
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM transactions WHERE chat_id = ? AND created_at < ?", chatID, cutoff.Format(timeLayout)).Scan(&count); err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	if count == 0 {
+		sendMessage(chatID, fmt.Sprintf("No transactions before %s.", cutoff.Format("January 2006")))
+		return
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Delete", fmt.Sprintf("purge:yes:%d", months)),
+		tgbotapi.NewInlineKeyboardButtonData("Cancel", "purge:no"),
+	))
+	sendMessageWithKeyboard(chatID,
+		fmt.Sprintf("Permanently delete %d transaction(s) from before %s?", count, cutoff.Format("January 2006")),
+		keyboard)
+}
+
+func processPurge(callback *tgbotapi.CallbackQuery) {
+	chatID, messageID := callback.Message.Chat.ID, callback.Message.MessageID
+	months, err := strconv.Atoi(strings.TrimPrefix(callback.Data, "purge:yes:"))
+	if callback.Data == "purge:no" || err != nil {
+		editMessage(chatID, messageID, "Purge cancelled.")
+		answerCallback(callback, "")
+		return
+	}
+
+	answerCallback(callback, "")
+	confirmWithCode(chatID, callback.From.ID, fmt.Sprintf("delete transactions older than %d month(s)", months), func() {
+		n, err := purgeBefore(db, chatID, retentionCutoff(months))
+		if err != nil {
+			editMessage(chatID, messageID, "Failed to purge transactions.")
+			log.Printf("Database exec error: %v", err)
+			return
+		}
+		log.Printf("Manual purge removed %d transaction(s) older than %d month(s)", n, months)
+		editMessage(chatID, messageID, fmt.Sprintf("Deleted %d transaction(s).", n))
+	})
+}
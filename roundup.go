@@ -0,0 +1,99 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// roundUpCategory is the category of the savings rows ROUND_UP_TO creates.
+// They have type "savings": money moved aside, counted against the balance
+// but not as spending.
+const roundUpCategory = "Round-up"
+
+// roundUpDifference is how much rounding amount up to the next multiple of
+// ROUND_UP_TO adds, or 0 when the feature is off or nothing is added.
+func roundUpDifference(amount float64) float64 {
+	if ROUND_UP_TO <= 0 {
+		return 0
+	}
+	diff := math.Ceil(amount/ROUND_UP_TO-1e-9)*ROUND_UP_TO - amount
+	if diff < 1e-9 {
+		return 0
+	}
+	return diff
+}
+
+// saveRoundUp logs the round-up of expense transactionID as a savings row
+// linked to it through round_up_of.
+func saveRoundUp(chatID int64, transactionID int64, amount float64, createdAt time.Time) (float64, error) {
+	diff := roundUpDifference(amount)
+	if diff == 0 {
+		return 0, nil
+	}
+	description, err := encryptField(fmt.Sprintf("Round-up of #%d", transactionID))
+	if err != nil {
+		return 0, err
+	}
+	_, err = db.Exec("INSERT INTO transactions (type, category, amount, description, created_at, chat_id, round_up_of) VALUES ('savings', ?, ?, ?, ?, ?, ?)",
+		roundUpCategory, diff, description, createdAt.Format(timeLayout), chatID, transactionID)
+	if err != nil {
+		return 0, err
+	}
+	return diff, nil
+}
+
+// syncRoundUp replaces the round-up of transaction id after it was edited,
+// moved to the trash or restored, so the savings row always matches the
+// expense it belongs to. Rows in the trash have no round-up.
+func syncRoundUp(chatID int64, id int64) error {
+	if _, err := deleteTransactions(db, "round_up_of = ? AND chat_id = ?", id, chatID); err != nil {
+		return err
+	}
+
+	var transactionType, createdAt string
+	var amount float64
+	var deleted bool
+	err := db.QueryRow("SELECT type, amount, strftime('%Y-%m-%d %H:%M:%S', created_at), deleted_at IS NOT NULL FROM transactions WHERE id = ? AND chat_id = ?",
+		id, chatID).Scan(&transactionType, &amount, &createdAt, &deleted)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if transactionType != "expense" || deleted {
+		return nil
+	}
+	at, err := time.ParseInLocation(timeLayout, createdAt, location)
+	if err != nil {
+		return err
+	}
+	_, err = saveRoundUp(chatID, id, amount, at)
+	return err
+}
+
+// refuseRoundUpEdit tells the user to change the expense instead when id
+// is a round-up row, reporting whether it did.
+func refuseRoundUpEdit(chatID int64, id int64) bool {
+	var source sql.NullInt64
+	err := db.QueryRow("SELECT round_up_of FROM transactions WHERE id = ? AND chat_id = ?", id, chatID).Scan(&source)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Database query error: %v", err)
+	}
+	if !source.Valid {
+		return false
+	}
+	sendMessage(chatID, fmt.Sprintf("Transaction #%d is the round-up of #%d and follows it; change #%d instead.", id, source.Int64, source.Int64))
+	return true
+}
+
+// querySavingsTotal sums the chat's savings rows created in [start, end).
+func querySavingsTotal(chatID int64, start, end time.Time) (float64, error) {
+	var total sql.NullFloat64
+	err := db.QueryRow("SELECT SUM(amount) FROM transactions WHERE chat_id = ? AND deleted_at IS NULL AND type = 'savings' AND created_at >= ? AND created_at < ?",
+		chatID, start.Format(timeLayout), end.Format(timeLayout)).Scan(&total)
+	return total.Float64, err
+}
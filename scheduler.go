@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// schedulerInterval is how often background jobs are checked.
+const schedulerInterval = time.Hour
+
+// scheduledRuns is read by the update loop in main, which runs the jobs
+// there since they use db, the profiles and the settings globals.
+var scheduledRuns = make(chan struct{})
+
+// startScheduler asks for the background jobs once at startup and then
+// every schedulerInterval. Each job must be safe to run repeatedly.
+func startScheduler() {
+	go func() {
+		for {
+			scheduledRuns <- struct{}{}
+			time.Sleep(schedulerInterval)
+		}
+	}()
+}
+
+// runScheduledJobs runs on the update loop.
+func runScheduledJobs() {
+	if err := runNudges(); err != nil {
+		log.Printf("Nudge job failed: %v", err)
+	}
+	if err := runIncomeAlert(); err != nil {
+		log.Printf("Income alert job failed: %v", err)
+	}
+	for _, name := range profileNames() {
+		if err := runRecurring(name, profiles[name]); err != nil {
+			log.Printf("Recurring job for profile %s failed: %v", name, err)
+		}
+		if err := runRetention(name, profiles[name]); err != nil {
+			log.Printf("Retention job for profile %s failed: %v", name, err)
+		}
+		if err := runTrashPurge(name, profiles[name]); err != nil {
+			log.Printf("Trash job for profile %s failed: %v", name, err)
+		}
+		if err := runMonthlyExport(name, profiles[name]); err != nil {
+			log.Printf("Export job for profile %s failed: %v", name, err)
+		}
+	}
+}
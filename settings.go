@@ -0,0 +1,467 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// globalSettingsUser is the settings.user_id under which bot-wide values are stored.
+const globalSettingsUser = 0
+
+// settingDef describes a key accepted by /set. Global settings change the
+// running configuration and can be seeded from an environment variable;
+// the others are stored per user and read where they are used.
+type settingDef struct {
+	key         string
+	env         string
+	description string
+	global      bool
+	apply       func(value string) error
+	current     func(userID int64) string
+}
+
+var settingDefs = []settingDef{
+	{
+		key:         "timezone",
+		env:         "TIMEZONE",
+		description: "IANA timezone for timestamps and reports",
+		global:      true,
+		apply: func(value string) error {
+			loc, err := time.LoadLocation(value)
+			if err != nil {
+				return errors.New("unknown timezone")
+			}
+			location = loc
+			return nil
+		},
+		current: func(int64) string { return location.String() },
+	},
+	{
+		key:         "default_type",
+		env:         "DEFAULT_TYPE",
+		description: "income, expense or none",
+		global:      true,
+		apply: func(value string) error {
+			value = strings.ToLower(value)
+			switch value {
+			case "none":
+				DEFAULT_TYPE = ""
+			case "income", "expense":
+				DEFAULT_TYPE = value
+			default:
+				return errors.New("must be income, expense or none")
+			}
+			return nil
+		},
+		current: func(int64) string {
+			if DEFAULT_TYPE == "" {
+				return "none"
+			}
+			return DEFAULT_TYPE
+		},
+	},
+	{
+		key:         "desc_required",
+		env:         "DESC_REQUIRED",
+		description: "true to require a description, false to allow /skip",
+		global:      true,
+		apply: func(value string) error {
+			v, err := strconv.ParseBool(value)
+			if err != nil {
+				return errors.New("must be true or false")
+			}
+			DESC_REQUIRED = v
+			return nil
+		},
+		current: func(int64) string { return strconv.FormatBool(DESC_REQUIRED) },
+	},
+	{
+		key:         "rounding_mode",
+		env:         "ROUNDING_MODE",
+		description: "nearest, up or down",
+		global:      true,
+		apply: func(value string) error {
+			value = strings.ToLower(value)
+			if value != "nearest" && value != "up" && value != "down" {
+				return errors.New("must be nearest, up or down")
+			}
+			ROUNDING_MODE = value
+			return nil
+		},
+		current: func(int64) string { return ROUNDING_MODE },
+	},
+	{
+		key:         "display_decimals",
+		env:         "DISPLAY_DECIMALS",
+		description: "decimal places shown in reports (0-6)",
+		global:      true,
+		apply: func(value string) error {
+			v, err := strconv.Atoi(value)
+			if err != nil || v < 0 || v > 6 {
+				return errors.New("must be a number from 0 to 6")
+			}
+			DISPLAY_DECIMALS = v
+			return nil
+		},
+		current: func(int64) string { return strconv.Itoa(DISPLAY_DECIMALS) },
+	},
+	{
+		key:         "currency_symbol",
+		env:         "CURRENCY_SYMBOL",
+		description: "symbol or code shown before amounts, or none",
+		global:      true,
+		apply: func(value string) error {
+			if strings.EqualFold(value, "none") {
+				CURRENCY_SYMBOL = ""
+				return nil
+			}
+			if err := validateCurrencySymbol(value); err != nil {
+				return err
+			}
+			CURRENCY_SYMBOL = value
+			return nil
+		},
+		current: func(int64) string {
+			if CURRENCY_SYMBOL == "" {
+				return "none"
+			}
+			return CURRENCY_SYMBOL
+		},
+	},
+	{
+		key:         "whole_number_amounts",
+		env:         "WHOLE_NUMBER_AMOUNTS",
+		description: "true to accept and show amounts without decimals",
+		global:      true,
+		apply: func(value string) error {
+			v, err := strconv.ParseBool(value)
+			if err != nil {
+				return errors.New("must be true or false")
+			}
+			WHOLE_NUMBER_AMOUNTS = v
+			return nil
+		},
+		current: func(int64) string { return strconv.FormatBool(WHOLE_NUMBER_AMOUNTS) },
+	},
+	{
+		key:         "category_columns",
+		env:         "CATEGORY_COLUMNS",
+		description: "category buttons per keyboard row (1-4)",
+		global:      true,
+		apply: func(value string) error {
+			v, err := strconv.Atoi(value)
+			if err != nil || v < 1 || v > 4 {
+				return errors.New("must be a number from 1 to 4")
+			}
+			CATEGORY_COLUMNS = v
+			return nil
+		},
+		current: func(int64) string { return strconv.Itoa(CATEGORY_COLUMNS) },
+	},
+	{
+		key:         "debug_timing",
+		env:         "DEBUG_TIMING",
+		description: "default for reporting how long each command took",
+		global:      true,
+		apply: func(value string) error {
+			v, err := strconv.ParseBool(value)
+			if err != nil {
+				return errors.New("must be true or false")
+			}
+			DEBUG_TIMING = v
+			return nil
+		},
+		current: func(int64) string { return strconv.FormatBool(DEBUG_TIMING) },
+	},
+	{
+		key:         "timing",
+		description: "true to report how long each of your commands took",
+		apply: func(value string) error {
+			if value != "true" && value != "false" {
+				return errors.New("must be true or false")
+			}
+			return nil
+		},
+		current: func(userID int64) string {
+			return getSetting(userID, "timing", strconv.FormatBool(DEBUG_TIMING))
+		},
+	},
+	{
+		key:         "follow_up_prompt",
+		env:         "FOLLOW_UP_PROMPT",
+		description: "default for offering /add again after a transaction",
+		global:      true,
+		apply: func(value string) error {
+			v, err := strconv.ParseBool(value)
+			if err != nil {
+				return errors.New("must be true or false")
+			}
+			FOLLOW_UP_PROMPT = v
+			return nil
+		},
+		current: func(int64) string { return strconv.FormatBool(FOLLOW_UP_PROMPT) },
+	},
+	{
+		key:         "follow_up",
+		description: "true to be offered /add again after a transaction",
+		apply: func(value string) error {
+			if value != "true" && value != "false" {
+				return errors.New("must be true or false")
+			}
+			return nil
+		},
+		current: func(userID int64) string {
+			return getSetting(userID, "follow_up", strconv.FormatBool(FOLLOW_UP_PROMPT))
+		},
+	},
+	{
+		key:         "strip_tags",
+		env:         "STRIP_TAGS",
+		description: "true to remove #tags from stored descriptions",
+		global:      true,
+		apply: func(value string) error {
+			v, err := strconv.ParseBool(value)
+			if err != nil {
+				return errors.New("must be true or false")
+			}
+			STRIP_TAGS = v
+			return nil
+		},
+		current: func(int64) string { return strconv.FormatBool(STRIP_TAGS) },
+	},
+	{
+		key:         "large_amount_threshold",
+		env:         "LARGE_AMOUNT_THRESHOLD",
+		description: "amounts above this need confirmation (0 disables)",
+		global:      true,
+		apply: func(value string) error {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil || v < 0 {
+				return errors.New("must be a non-negative number")
+			}
+			LARGE_AMOUNT_THRESHOLD = v
+			return nil
+		},
+		current: func(int64) string { return strconv.FormatFloat(LARGE_AMOUNT_THRESHOLD, 'f', -1, 64) },
+	},
+	{
+		key:         "round_up_to",
+		env:         "ROUND_UP_TO",
+		description: "round expenses up to a multiple of this into savings (0 disables)",
+		global:      true,
+		apply: func(value string) error {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil || v < 0 {
+				return errors.New("must be a non-negative number")
+			}
+			ROUND_UP_TO = v
+			return nil
+		},
+		current: func(int64) string { return strconv.FormatFloat(ROUND_UP_TO, 'f', -1, 64) },
+	},
+	{
+		key:         "entry_decimals",
+		env:         "ENTRY_DECIMALS",
+		description: "round typed amounts to this many decimals before saving (-1 disables)",
+		global:      true,
+		apply: func(value string) error {
+			v, err := strconv.Atoi(value)
+			if err != nil || v < -1 || v > 6 {
+				return errors.New("must be a number from -1 to 6")
+			}
+			ENTRY_DECIMALS = v
+			return nil
+		},
+		current: func(int64) string { return strconv.Itoa(ENTRY_DECIMALS) },
+	},
+	{
+		key:         "monthly_budget",
+		env:         "MONTHLY_BUDGET",
+		description: "overall monthly spending budget shown after each expense (0 disables)",
+		global:      true,
+		apply: func(value string) error {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil || v < 0 {
+				return errors.New("must be a non-negative number")
+			}
+			MONTHLY_BUDGET = v
+			return nil
+		},
+		current: func(int64) string { return strconv.FormatFloat(MONTHLY_BUDGET, 'f', -1, 64) },
+	},
+	{
+		key:         "monthly_savings_goal",
+		env:         "MONTHLY_SAVINGS_GOAL",
+		description: "amount to save each month, shown with /monthly_goal (0 disables)",
+		global:      true,
+		apply: func(value string) error {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil || v < 0 {
+				return errors.New("must be a non-negative number")
+			}
+			MONTHLY_SAVINGS_GOAL = v
+			return nil
+		},
+		current: func(int64) string { return strconv.FormatFloat(MONTHLY_SAVINGS_GOAL, 'f', -1, 64) },
+	},
+	{
+		key:         "summary_format",
+		description: "compact or detailed output for /summary",
+		apply: func(value string) error {
+			if value != "compact" && value != "detailed" {
+				return errors.New("must be compact or detailed")
+			}
+			return nil
+		},
+		current: func(userID int64) string { return getSetting(userID, "summary_format", "compact") },
+	},
+	{
+		key:         "summary_carryover",
+		description: "true to open /summary with the balance of earlier months",
+		apply: func(value string) error {
+			if value != "true" && value != "false" {
+				return errors.New("must be true or false")
+			}
+			return nil
+		},
+		current: func(userID int64) string { return getSetting(userID, "summary_carryover", "false") },
+	},
+	{
+		key:         "ask_location",
+		description: "true to be asked for a location after the description",
+		apply: func(value string) error {
+			if value != "true" && value != "false" {
+				return errors.New("must be true or false")
+			}
+			return nil
+		},
+		current: func(userID int64) string { return getSetting(userID, "ask_location", "false") },
+	},
+	{
+		key:         "ask_payment_method",
+		description: "true to be asked how you paid after the description",
+		apply: func(value string) error {
+			if value != "true" && value != "false" {
+				return errors.New("must be true or false")
+			}
+			return nil
+		},
+		current: func(userID int64) string { return getSetting(userID, "ask_payment_method", "false") },
+	},
+	{
+		key:         "desc_overflow",
+		description: "reject or trim descriptions over 100 characters",
+		apply: func(value string) error {
+			if value != "reject" && value != "trim" {
+				return errors.New("must be reject or trim")
+			}
+			return nil
+		},
+		current: func(userID int64) string { return getSetting(userID, "desc_overflow", "reject") },
+	},
+}
+
+func findSettingDef(key string) (settingDef, bool) {
+	for _, def := range settingDefs {
+		if def.key == key {
+			return def, true
+		}
+	}
+	return settingDef{}, false
+}
+
+// getSetting returns the stored value of a per-user setting, or def when
+// it has never been set.
+func getSetting(userID int64, key string, def string) string {
+	var value string
+	err := mainDB.QueryRow("SELECT value FROM settings WHERE user_id = ? AND key = ?", userID, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return def
+	}
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+		return def
+	}
+	return value
+}
+
+func setSetting(userID int64, key string, value string) error {
+	_, err := mainDB.Exec(`INSERT INTO settings (user_id, key, value) VALUES (?, ?, ?)
+		ON CONFLICT(user_id, key) DO UPDATE SET value = excluded.value`, userID, key, value)
+	return err
+}
+
+// loadGlobalSettings applies values saved with /set over the environment.
+func loadGlobalSettings() {
+	for _, def := range settingDefs {
+		if !def.global {
+			continue
+		}
+		value := getSetting(globalSettingsUser, def.key, "")
+		if value == "" {
+			continue
+		}
+		if err := def.apply(value); err != nil {
+			log.Printf("Ignoring stored setting %s=%q: %v", def.key, value, err)
+		}
+	}
+}
+
+func showSettings(chatID int64, userID int64) {
+	text := "Settings:\n\n"
+	for _, def := range settingDefs {
+		text += fmt.Sprintf("%s = %s\n  %s\n", def.key, def.current(userID), def.description)
+	}
+	text += "\nChange one with /set <key> <value>."
+	sendMessage(chatID, text)
+}
+
+func handleSet(chatID int64, userID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		sendMessage(chatID, "Usage: /set <key> <value>")
+		return
+	}
+	key, value := strings.ToLower(fields[0]), fields[1]
+	if key != "timezone" && key != "currency_symbol" {
+		value = strings.ToLower(value)
+	}
+
+	def, ok := findSettingDef(key)
+	if !ok {
+		sendMessage(chatID, fmt.Sprintf("Unknown setting: %s. See /settings.", key))
+		return
+	}
+	// Global values apply to every chat, so only the owner may change them
+	if def.global && userID != ALLOWED_USER_ID {
+		sendMessage(chatID, fmt.Sprintf("Only the bot owner can change %s.", key))
+		return
+	}
+	// apply changes a global right away, so keep the old value to put back
+	// if it can't be saved
+	previous := def.current(userID)
+	if err := def.apply(value); err != nil {
+		sendMessage(chatID, fmt.Sprintf("Invalid value for %s: %v", key, err))
+		return
+	}
+
+	// Global values are stored in their normalized form, e.g. "none" for default_type
+	owner, stored := userID, value
+	if def.global {
+		owner, stored = globalSettingsUser, def.current(userID)
+	}
+	if err := setSetting(owner, key, stored); err != nil {
+		if def.global {
+			def.apply(previous)
+		}
+		sendMessage(chatID, "Failed to save setting.")
+		log.Printf("Database exec error: %v", err)
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("%s set to %s.", key, def.current(userID)))
+}
@@ -24,8 +24,12 @@ def fetch_data_from_db(db_path):
     conn = sqlite3.connect(db_path)
     cursor = conn.cursor()
     
-    # Fetching all data from the transactions table
-    cursor.execute("SELECT * FROM transactions")
+    # Fetching cleared transactions, leaving out the trash and pending ones
+    cursor.execute("""
+        SELECT id, type, category, amount, description, created_at
+        FROM transactions
+        WHERE deleted_at IS NULL AND status = 'cleared'
+    """)
     rows = cursor.fetchall()
     
     conn.close()
@@ -90,9 +94,10 @@ def generate_excel_report(data, report_file):
         month_data[month_str].append(row)
 
         amount = row[3]
+        # Round-up savings rows are neither income nor expense
         if row[1] == 'expense':
             monthly_income_expense[month_str]["expense"] += amount
-        else:
+        elif row[1] == 'income':
             monthly_income_expense[month_str]["income"] += amount
 
     for month, totals in monthly_income_expense.items():
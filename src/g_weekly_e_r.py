@@ -27,7 +27,8 @@ start_date = today - timedelta(days=6)
 query = '''
     SELECT DATE(created_at) as date, SUM(amount) as total_expense
     FROM transactions
-    WHERE type = 'expense' AND DATE(created_at) BETWEEN ? AND ?
+    WHERE type = 'expense' AND deleted_at IS NULL AND status = 'cleared'
+        AND DATE(created_at) BETWEEN ? AND ?
     GROUP BY DATE(created_at)
     ORDER BY DATE(created_at)
 '''
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// showStreak reports two streaks in the configured timezone:
+//
+//   - logging: consecutive days with at least one transaction, ending today,
+//     or yesterday if nothing has been logged yet today;
+//   - under limit (only with a daily limit argument): consecutive days up to
+//     today whose expenses stayed at or below the limit, where days without
+//     expenses count as under, going back no further than the first
+//     transaction.
+func showStreak(chatID int64, args string) {
+	limit := 0.0
+	if arg := strings.TrimSpace(args); arg != "" {
+		var err error
+		limit, err = parseAmount(arg)
+		if err != nil || limit <= 0 {
+			sendMessage(chatID, "Usage: /streak [daily limit]")
+			return
+		}
+	}
+
+	daily, err := queryDailyExpenses(chatID)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	if len(daily) == 0 {
+		sendMessage(chatID, "No transactions yet, so no streak.")
+		return
+	}
+
+	now := time.Now().In(location)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	first := today
+	for day := range daily {
+		if d, err := time.ParseInLocation("2006-01-02", day, location); err == nil && d.Before(first) {
+			first = d
+		}
+	}
+
+	day := today
+	if _, ok := daily[day.Format("2006-01-02")]; !ok {
+		day = day.AddDate(0, 0, -1)
+	}
+	logging := 0
+	for ; ; day = day.AddDate(0, 0, -1) {
+		if _, ok := daily[day.Format("2006-01-02")]; !ok {
+			break
+		}
+		logging++
+	}
+	longest, run := 0, 0
+	for d := first; !d.After(today); d = d.AddDate(0, 0, 1) {
+		if _, ok := daily[d.Format("2006-01-02")]; ok {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+
+	text := fmt.Sprintf("Logging streak: %d day(s)\nLongest: %d day(s)", logging, longest)
+	if logging == 0 {
+		text += "\n\nLog something today to start a new streak."
+	}
+
+	if limit > 0 {
+		under := 0
+		for d := today; !d.Before(first); d = d.AddDate(0, 0, -1) {
+			if daily[d.Format("2006-01-02")] > limit {
+				break
+			}
+			under++
+		}
+		text += fmt.Sprintf("\n\nUnder %s a day: %d day(s) in a row", formatAmount(limit), under)
+	}
+	sendMessage(chatID, text)
+}
+
+// queryDailyExpenses returns the chat's expense total for every day that
+// has any transaction, keyed by YYYY-MM-DD. Days with only income map to 0.
+func queryDailyExpenses(chatID int64) (map[string]float64, error) {
+	rows, err := db.Query(`SELECT strftime('%Y-%m-%d', created_at) AS day, SUM(CASE WHEN type = 'expense' THEN amount ELSE 0 END)
+		FROM transactions WHERE chat_id = ? AND deleted_at IS NULL GROUP BY day`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	daily := make(map[string]float64)
+	for rows.Next() {
+		var day string
+		var expense float64
+		if err := rows.Scan(&day, &expense); err != nil {
+			return nil, err
+		}
+		daily[day] = expense
+	}
+	return daily, rows.Err()
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// suggestMinMonths is how many consecutive months a payment must
+	// repeat before it is proposed as recurring.
+	suggestMinMonths = 3
+	// suggestTolerance is the relative amount difference still treated
+	// as the same bill.
+	suggestTolerance = 0.1
+)
+
+// recurringSuggestions holds the templates last proposed in each chat, so
+// the Accept buttons only need to carry an index.
+var recurringSuggestions = make(map[int64][]recurringTemplate)
+
+// suggestRecurring looks for payments of the same type and category with a
+// similar amount that appeared once a month for the last few months, and
+// offers to turn each into a recurring template.
+func suggestRecurring(chatID int64) {
+	start := time.Now().In(location).AddDate(0, -12, 0)
+	transactions, err := queryTransactions(
+		"SELECT "+transactionColumns+" FROM transactions WHERE chat_id = ? AND deleted_at IS NULL AND created_at >= ? ORDER BY created_at",
+		chatID, start.Format(timeLayout),
+	)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	existing, err := queryRecurring(db, chatID)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving recurring transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+
+	// Group by type and category, then cluster rows by amount
+	groups := make(map[string][][]Transaction)
+	var keys []string
+	for _, t := range transactions {
+		key := t.Type + "/" + t.Category
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		clusters := groups[key]
+		placed := false
+		for i, cluster := range clusters {
+			if similarAmount(cluster[0].Amount, t.Amount) {
+				clusters[i] = append(cluster, t)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			clusters = append(clusters, []Transaction{t})
+		}
+		groups[key] = clusters
+	}
+
+	var suggestions []recurringTemplate
+	for _, key := range keys {
+		for _, cluster := range groups[key] {
+			r, ok := monthlyTemplate(cluster)
+			if !ok || hasSimilarRecurring(existing, r) {
+				continue
+			}
+			r.ChatID = chatID
+			suggestions = append(suggestions, r)
+		}
+	}
+	if len(suggestions) == 0 {
+		sendMessage(chatID, "No repeating monthly payments found.")
+		return
+	}
+
+	recurringSuggestions[chatID] = suggestions
+	sendMessage(chatID, fmt.Sprintf("Found %d payment(s) that look monthly:", len(suggestions)))
+	for i, r := range suggestions {
+		text := fmt.Sprintf("%s %s %s around day %d", r.Type, r.Category, formatAmount(r.Amount), r.Day)
+		if r.Description != "" {
+			text += " — " + r.Description
+		}
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Accept", fmt.Sprintf("suggest:accept:%d", i)),
+			tgbotapi.NewInlineKeyboardButtonData("Ignore", "suggest:ignore"),
+		))
+		sendMessageWithKeyboard(chatID, text, keyboard)
+	}
+}
+
+func similarAmount(a, b float64) bool {
+	return math.Abs(a-b) <= suggestTolerance*math.Max(a, b)
+}
+
+// monthlyTemplate turns a cluster into a template when it has exactly one
+// row in each of the last suggestMinMonths or more consecutive months,
+// ending this month or last month. The latest row supplies the amount and
+// description, and the median day of month becomes the due day.
+func monthlyTemplate(cluster []Transaction) (recurringTemplate, bool) {
+	months := make(map[string]bool)
+	var days []int
+	for _, t := range cluster {
+		month := t.CreatedAt[:7]
+		if months[month] {
+			return recurringTemplate{}, false
+		}
+		months[month] = true
+		day, _ := strconv.Atoi(t.CreatedAt[8:10])
+		days = append(days, day)
+	}
+	if len(months) < suggestMinMonths {
+		return recurringTemplate{}, false
+	}
+
+	now := time.Now().In(location)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, location)
+	if !months[month.Format("2006-01")] {
+		month = month.AddDate(0, -1, 0)
+	}
+	for i := 0; i < suggestMinMonths; i++ {
+		if !months[month.AddDate(0, -i, 0).Format("2006-01")] {
+			return recurringTemplate{}, false
+		}
+	}
+
+	sort.Ints(days)
+	latest := cluster[len(cluster)-1]
+	return recurringTemplate{
+		Type:        latest.Type,
+		Category:    latest.Category,
+		Amount:      latest.Amount,
+		Description: latest.Description,
+		Day:         days[len(days)/2],
+	}, true
+}
+
+func hasSimilarRecurring(existing []recurringTemplate, r recurringTemplate) bool {
+	for _, e := range existing {
+		if e.Type == r.Type && e.Category == r.Category && similarAmount(e.Amount, r.Amount) {
+			return true
+		}
+	}
+	return false
+}
+
+func processSuggestRecurring(callback *tgbotapi.CallbackQuery) {
+	chatID, messageID := callback.Message.Chat.ID, callback.Message.MessageID
+	if callback.Data == "suggest:ignore" {
+		editMessage(chatID, messageID, callback.Message.Text+"\n\nIgnored.")
+		answerCallback(callback, "")
+		return
+	}
+
+	i, err := strconv.Atoi(strings.TrimPrefix(callback.Data, "suggest:accept:"))
+	suggestions := recurringSuggestions[chatID]
+	if err != nil || i < 0 || i >= len(suggestions) {
+		answerCallback(callback, "This button has expired")
+		return
+	}
+
+	r := suggestions[i]
+	description, err := encryptField(r.Description)
+	if err != nil {
+		answerCallback(callback, "Failed to encrypt the description")
+		log.Printf("Encryption error: %v", err)
+		return
+	}
+	r.Description = description
+	text, err := saveRecurring(r)
+	if err != nil {
+		answerCallback(callback, "Failed to save recurring transaction")
+		log.Printf("Database exec error: %v", err)
+		return
+	}
+	editMessage(chatID, messageID, text)
+	answerCallback(callback, "Saved")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+var tagPattern = regexp.MustCompile(`#([\p{L}\p{N}_]+)`)
+
+// extractTags returns the lowercase #tags in text, without duplicates.
+// When STRIP_TAGS is enabled the tags are also removed from the text.
+func extractTags(text string) (string, []string) {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, match := range tagPattern.FindAllStringSubmatch(text, -1) {
+		tag := strings.ToLower(match[1])
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	if STRIP_TAGS && len(tags) > 0 {
+		text = strings.Join(strings.Fields(tagPattern.ReplaceAllString(text, "")), " ")
+	}
+	return text, tags
+}
+
+func saveTags(transactionID int64, tags []string) {
+	for _, tag := range tags {
+		if _, err := db.Exec("INSERT OR IGNORE INTO tags (transaction_id, tag) VALUES (?, ?)", transactionID, tag); err != nil {
+			log.Printf("Failed to save tag %s for transaction %d: %v", tag, transactionID, err)
+		}
+	}
+}
+
+func showTag(chatID int64, args string) {
+	tag := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(args), "#"))
+	if tag == "" || strings.ContainsAny(tag, " \t") {
+		sendMessage(chatID, "Usage: /tag <name>")
+		return
+	}
+
+	transactions, err := queryTransactions(
+		"SELECT "+transactionColumns+" FROM transactions WHERE chat_id = ? AND deleted_at IS NULL AND id IN (SELECT transaction_id FROM tags WHERE tag = ?) ORDER BY created_at",
+		chatID, tag,
+	)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	if len(transactions) == 0 {
+		sendMessage(chatID, fmt.Sprintf("No transactions tagged #%s.", tag))
+		return
+	}
+
+	income, expense := 0.0, 0.0
+	for _, t := range transactions {
+		if t.Type == "income" {
+			income += t.Amount
+		} else {
+			expense += t.Amount
+		}
+	}
+	text := fmt.Sprintf("Transactions tagged #%s:\n\n%s\nExpense: %s", tag, formatTransactionList(transactions), formatAmount(expense))
+	if income > 0 {
+		text += fmt.Sprintf("\nIncome: %s", formatAmount(income))
+	}
+	sendLongMessage(chatID, text)
+}
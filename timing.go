@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// commandTimer measures a command for the timing setting. The time taken
+// is appended to the first text message the command sends, which for a
+// report is the report itself.
+type commandTimer struct {
+	chatID  int64
+	command string
+	started time.Time
+}
+
+// activeTimer belongs to the command the update loop is handling. Report
+// goroutines also send messages, hence the mutex.
+var (
+	timerMu     sync.Mutex
+	activeTimer *commandTimer
+)
+
+func startTimer(chatID int64, command string) {
+	timerMu.Lock()
+	defer timerMu.Unlock()
+	activeTimer = &commandTimer{chatID: chatID, command: command, started: time.Now()}
+}
+
+// takeTimer hands over the running timer for chatID, if any, so only one
+// message carries the timing. Reports that run in the background take it
+// before they start and annotate their output when it is ready.
+func takeTimer(chatID int64) *commandTimer {
+	timerMu.Lock()
+	defer timerMu.Unlock()
+	t := activeTimer
+	if t == nil || t.chatID != chatID {
+		return nil
+	}
+	activeTimer = nil
+	return t
+}
+
+// annotate appends the time taken so far to text. A nil timer leaves text
+// unchanged.
+func (t *commandTimer) annotate(text string) string {
+	if t == nil {
+		return text
+	}
+	note := fmt.Sprintf("(/%s took %.2fs)", t.command, time.Since(t.started).Seconds())
+	if text == "" {
+		return note
+	}
+	return text + "\n\n" + note
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Transaction is a row of the transactions table.
+type Transaction struct {
+	ID          int64
+	Type        string
+	Category    string
+	Amount      float64
+	Description string
+	CreatedAt   string
+	Reference   string
+}
+
+// transactionColumns is the column list queryTransactions expects a query to select.
+const transactionColumns = "id, type, category, amount, COALESCE(description, ''), strftime('%Y-%m-%d %H:%M:%S', created_at), COALESCE(reference, '')"
+
+func queryTransactions(query string, args ...interface{}) ([]Transaction, error) {
+	return queryTransactionsIn(db, query, args...)
+}
+
+// queryTransactionsIn runs query against a specific profile's database,
+// for background jobs that go through every profile.
+func queryTransactionsIn(conn *sql.DB, query string, args ...interface{}) ([]Transaction, error) {
+	rows, err := conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transactions []Transaction
+	for rows.Next() {
+		var t Transaction
+		if err := rows.Scan(&t.ID, &t.Type, &t.Category, &t.Amount, &t.Description, &t.CreatedAt, &t.Reference); err != nil {
+			return nil, err
+		}
+		if t.Description, err = decryptField(t.Description); err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, t)
+	}
+	return transactions, rows.Err()
+}
+
+// formatTransactionList renders one line per transaction.
+func formatTransactionList(transactions []Transaction) string {
+	var sb strings.Builder
+	for _, t := range transactions {
+		sb.WriteString(fmt.Sprintf("#%d %s %s %s %s", t.ID, t.CreatedAt[:10], t.Type, t.Category, formatAmount(t.Amount)))
+		if t.Description != "" {
+			sb.WriteString(" — " + t.Description)
+		}
+		if t.Reference != "" {
+			sb.WriteString(" [ref " + t.Reference + "]")
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func findByAmount(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) < 1 || len(fields) > 2 {
+		sendMessage(chatID, "Usage: /find_amount <value> [tolerance]")
+		return
+	}
+
+	value, err := parseAmount(fields[0])
+	if err != nil || value <= 0 {
+		sendMessage(chatID, "Invalid amount. Please enter a positive number.")
+		return
+	}
+	tolerance := 0.0
+	if len(fields) == 2 {
+		tolerance, err = parseAmount(fields[1])
+		if err != nil {
+			sendMessage(chatID, "Invalid tolerance. Please enter a non-negative number.")
+			return
+		}
+	}
+
+	transactions, err := queryTransactions(
+		"SELECT "+transactionColumns+" FROM transactions WHERE chat_id = ? AND deleted_at IS NULL AND amount BETWEEN ? AND ? ORDER BY created_at DESC LIMIT 50",
+		chatID, value-tolerance, value+tolerance,
+	)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	if len(transactions) == 0 {
+		sendMessage(chatID, "No transactions found with that amount.")
+		return
+	}
+
+	sendLongMessage(chatID, fmt.Sprintf("Transactions matching %s ± %s:\n\n%s", formatAmount(value), formatAmount(tolerance), formatTransactionList(transactions)))
+}
+
+// extractDateToken removes an "@YYYY-MM-DD" token from text and returns it
+// as that day at the current time of day in the configured timezone. The
+// date is zero when no token is present; future dates are rejected.
+func extractDateToken(text string) (string, time.Time, error) {
+	fields := strings.Fields(text)
+	var date time.Time
+	kept := fields[:0]
+	for _, field := range fields {
+		if !strings.HasPrefix(field, "@") || len(field) == 1 {
+			kept = append(kept, field)
+			continue
+		}
+		if !date.IsZero() {
+			return "", time.Time{}, fmt.Errorf("only one @date is allowed")
+		}
+		day, err := time.ParseInLocation("2006-01-02", field[1:], location)
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("%s is not a YYYY-MM-DD date", field)
+		}
+		now := time.Now().In(location)
+		date = time.Date(day.Year(), day.Month(), day.Day(), now.Hour(), now.Minute(), now.Second(), 0, location)
+		if date.After(now) {
+			return "", time.Time{}, fmt.Errorf("%s is in the future", field[1:])
+		}
+	}
+	if date.IsZero() {
+		return text, date, nil
+	}
+	return strings.Join(kept, " "), date, nil
+}
+
+// extractReference removes a "ref:<value>" token from text, such as an
+// invoice number for reimbursements, and returns its value.
+func extractReference(text string) (string, string, error) {
+	fields := strings.Fields(text)
+	reference := ""
+	kept := fields[:0]
+	for _, field := range fields {
+		if len(field) < 4 || !strings.EqualFold(field[:4], "ref:") {
+			kept = append(kept, field)
+			continue
+		}
+		value := field[4:]
+		if value == "" {
+			return "", "", fmt.Errorf("ref: needs a value, e.g. ref:INV123")
+		}
+		if reference != "" {
+			return "", "", fmt.Errorf("only one ref: is allowed")
+		}
+		if len([]rune(value)) > 50 {
+			return "", "", fmt.Errorf("references are limited to 50 characters")
+		}
+		reference = value
+	}
+	if reference == "" {
+		return text, "", nil
+	}
+	return strings.Join(kept, " "), reference, nil
+}
+
+func findByReference(chatID int64, args string) {
+	reference := strings.TrimSpace(args)
+	if reference == "" {
+		sendMessage(chatID, "Usage: /find_ref <value>")
+		return
+	}
+
+	transactions, err := queryTransactions(
+		"SELECT "+transactionColumns+" FROM transactions WHERE chat_id = ? AND deleted_at IS NULL AND reference LIKE ? ESCAPE '\\' ORDER BY created_at",
+		chatID, "%"+escapeLike(reference)+"%",
+	)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	if len(transactions) == 0 {
+		sendMessage(chatID, fmt.Sprintf("No transactions with reference %s.", reference))
+		return
+	}
+	sendLongMessage(chatID, fmt.Sprintf("Transactions with reference %s:\n\n%s", reference, formatTransactionList(transactions)))
+}
+
+// escapeLike escapes the LIKE wildcards in s for use with ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(s)
+}
+
+// getTransaction loads a single transaction of the chat, returning
+// sql.ErrNoRows when the id doesn't exist there.
+func getTransaction(chatID int64, id int64) (Transaction, error) {
+	transactions, err := queryTransactions("SELECT "+transactionColumns+" FROM transactions WHERE chat_id = ? AND deleted_at IS NULL AND id = ?", chatID, id)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if len(transactions) == 0 {
+		return Transaction{}, sql.ErrNoRows
+	}
+	return transactions[0], nil
+}
+
+func startRecategorize(chatID int64, userID int64, args string) {
+	cmd := newCommandArgs(args, "/recategorize <id>")
+	id := cmd.id()
+	if !cmd.ok(chatID) || refuseRoundUpEdit(chatID, id) {
+		return
+	}
+	t, err := getTransaction(chatID, id)
+	if err == sql.ErrNoRows {
+		sendMessage(chatID, fmt.Sprintf("Transaction #%d not found.", id))
+		return
+	}
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transaction.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+
+	userStates[stateKey{chatID, userID}] = &TransactionState{
+		ChatID:        chatID,
+		UserID:        userID,
+		Step:          "RECATEGORIZE",
+		TransactionID: id,
+		Category:      t.Category,
+		Description:   t.Description,
+	}
+
+	buttons := categoryButtons()
+	buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Cancel", "recategorize:cancel"),
+	))
+	sendMessageWithKeyboard(chatID,
+		fmt.Sprintf("%sCurrent category: %s. Choose the new category:", formatTransactionList([]Transaction{t}), t.Category),
+		tgbotapi.NewInlineKeyboardMarkup(buttons...))
+}
+
+func processRecategorize(callback *tgbotapi.CallbackQuery, state *TransactionState) {
+	chatID := callback.Message.Chat.ID
+	if callback.Data == "recategorize:cancel" {
+		clearState(state)
+		editMessage(chatID, callback.Message.MessageID, "Recategorize cancelled.")
+		answerCallback(callback, "")
+		return
+	}
+
+	category, ok := findCategory(callback.Data)
+	if !ok {
+		answerCallback(callback, "Unknown category")
+		return
+	}
+	if _, err := db.Exec("UPDATE transactions SET category = ? WHERE id = ?", category, state.TransactionID); err != nil {
+		answerCallback(callback, "Failed to update transaction")
+		log.Printf("Database exec error: %v", err)
+		return
+	}
+
+	// Moving a row is a correction of its old category
+	if category != state.Category {
+		learnCategory(chatID, state.Description, category, correctionWeight)
+		learnCategory(chatID, state.Description, state.Category, penaltyWeight)
+	}
+
+	clearState(state)
+	editMessage(chatID, callback.Message.MessageID,
+		fmt.Sprintf("Transaction #%d moved from %s to %s.", state.TransactionID, state.Category, category))
+	answerCallback(callback, "Category updated")
+}
+
+// rememberConfirmation links a sent confirmation message to its transaction.
+func rememberConfirmation(chatID int64, messageID int, transactionID int64) {
+	if messageID == 0 || transactionID == 0 {
+		return
+	}
+	if _, err := db.Exec("INSERT OR REPLACE INTO confirmations (chat_id, message_id, transaction_id) VALUES (?, ?, ?)",
+		chatID, messageID, transactionID); err != nil {
+		log.Printf("Failed to remember confirmation message: %v", err)
+	}
+}
+
+// editByReply updates the amount of the transaction whose confirmation the
+// message replies to. It returns false when the replied-to message isn't a
+// known confirmation, so the message is handled normally.
+func editByReply(message *tgbotapi.Message) bool {
+	var id int64
+	err := db.QueryRow("SELECT transaction_id FROM confirmations WHERE chat_id = ? AND message_id = ?",
+		message.Chat.ID, message.ReplyToMessage.MessageID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+		return false
+	}
+
+	t, err := getTransaction(message.Chat.ID, id)
+	if err == sql.ErrNoRows {
+		sendMessage(message.Chat.ID, fmt.Sprintf("Transaction #%d no longer exists.", id))
+		return true
+	}
+	if err != nil {
+		sendMessage(message.Chat.ID, "Error retrieving transaction.")
+		log.Printf("Database query error: %v", err)
+		return true
+	}
+
+	amount, err := parseAmount(message.Text)
+	if err != nil || amount <= 0 || amount > maxAmount {
+		sendMessage(message.Chat.ID, "Reply with a positive amount to change this transaction.")
+		return true
+	}
+	if _, err := db.Exec("UPDATE transactions SET amount = ? WHERE id = ?", amount, id); err != nil {
+		sendMessage(message.Chat.ID, "Failed to update transaction.")
+		log.Printf("Database exec error: %v", err)
+		return true
+	}
+	if err := syncRoundUp(message.Chat.ID, id); err != nil {
+		log.Printf("Failed to update round-up of transaction %d: %v", id, err)
+	}
+
+	sendMessage(message.Chat.ID, fmt.Sprintf("Transaction #%d amount changed from %s to %s.", id, formatAmount(t.Amount), formatAmount(amount)))
+	refreshPin(message.Chat.ID)
+	return true
+}
+
+// confirmPending marks a pending transaction as cleared, or lists the
+// chat's pending transactions when no id is given.
+func confirmPending(chatID int64, args string) {
+	cmd := newCommandArgs(args, "/confirm [id]")
+	if !cmd.has() {
+		transactions, err := queryTransactions("SELECT "+transactionColumns+" FROM transactions WHERE chat_id = ? AND deleted_at IS NULL AND status = 'pending' ORDER BY created_at", chatID)
+		if err != nil {
+			sendMessage(chatID, "Error retrieving transactions.")
+			log.Printf("Database query error: %v", err)
+			return
+		}
+		if len(transactions) == 0 {
+			sendMessage(chatID, "No pending transactions.")
+			return
+		}
+		sendLongMessage(chatID, "Pending transactions:\n\n"+formatTransactionList(transactions)+"\nConfirm one with /confirm <id>.")
+		return
+	}
+
+	id := cmd.id()
+	if !cmd.ok(chatID) {
+		return
+	}
+	result, err := db.Exec("UPDATE transactions SET status = 'cleared' WHERE id = ? AND chat_id = ? AND status = 'pending'", id, chatID)
+	if err != nil {
+		sendMessage(chatID, "Failed to update transaction.")
+		log.Printf("Database exec error: %v", err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		sendMessage(chatID, fmt.Sprintf("Transaction #%d is not pending.", id))
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("Transaction #%d confirmed and now counts in summaries.", id))
+	refreshPin(chatID)
+}
+
+// noteLatest edits the description of the chat's most recently added
+// transaction. Text is appended by default; starting it with "=" replaces
+// the description instead.
+func noteLatest(chatID int64, args string) {
+	text := strings.TrimSpace(args)
+	replace := strings.HasPrefix(text, "=")
+	if replace {
+		text = strings.TrimSpace(strings.TrimPrefix(text, "="))
+	}
+	if text == "" {
+		sendMessage(chatID, "Usage: /note <text> to append, or /note = <text> to replace the description of your latest transaction")
+		return
+	}
+
+	transactions, err := queryTransactions("SELECT "+transactionColumns+" FROM transactions WHERE chat_id = ? AND deleted_at IS NULL AND round_up_of IS NULL ORDER BY id DESC LIMIT 1", chatID)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	if len(transactions) == 0 {
+		sendMessage(chatID, "No transactions yet.")
+		return
+	}
+	t := transactions[0]
+
+	text, tags := extractTags(text)
+	description := text
+	if !replace && t.Description != "" {
+		description = t.Description + " " + text
+	}
+	if len([]rune(description)) > 100 {
+		sendMessage(chatID, "Description too long. Please keep it under 100 characters.")
+		return
+	}
+	if DESC_REQUIRED && strings.TrimSpace(description) == "" {
+		sendMessage(chatID, "Description can't be empty.")
+		return
+	}
+
+	stored, err := encryptField(description)
+	if err != nil {
+		sendMessage(chatID, "Failed to encrypt the description.")
+		log.Printf("Encryption error: %v", err)
+		return
+	}
+	if _, err := db.Exec("UPDATE transactions SET description = ? WHERE id = ?", stored, t.ID); err != nil {
+		sendMessage(chatID, "Failed to update transaction.")
+		log.Printf("Database exec error: %v", err)
+		return
+	}
+	if replace {
+		if _, err := db.Exec("DELETE FROM tags WHERE transaction_id = ?", t.ID); err != nil {
+			log.Printf("Database exec error: %v", err)
+		}
+	}
+	saveTags(t.ID, tags)
+
+	sendMessage(chatID, fmt.Sprintf("Transaction #%d description: %s", t.ID, description))
+}
+
+// setTransactionDate moves a transaction to another day, keeping its time
+// of day, so it lands in the right month.
+func setTransactionDate(chatID int64, args string) {
+	cmd := newCommandArgs(args, "/setdate <id> <YYYY-MM-DD>")
+	id := cmd.id()
+	day := cmd.date()
+	if !cmd.ok(chatID) || refuseRoundUpEdit(chatID, id) {
+		return
+	}
+	now := time.Now().In(location)
+	if day.After(now) {
+		sendMessage(chatID, fmt.Sprintf("%s is in the future.", day.Format("2006-01-02")))
+		return
+	}
+
+	t, err := getTransaction(chatID, id)
+	if err == sql.ErrNoRows {
+		sendMessage(chatID, fmt.Sprintf("Transaction #%d not found.", id))
+		return
+	}
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transaction.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	old, err := time.ParseInLocation(timeLayout, t.CreatedAt, location)
+	if err != nil {
+		old = day
+	}
+	date := time.Date(day.Year(), day.Month(), day.Day(), old.Hour(), old.Minute(), old.Second(), 0, location)
+	if date.After(now) {
+		date = now
+	}
+
+	if _, err := db.Exec("UPDATE transactions SET created_at = ? WHERE id = ?", date.Format(timeLayout), id); err != nil {
+		sendMessage(chatID, "Failed to update transaction.")
+		log.Printf("Database exec error: %v", err)
+		return
+	}
+	if err := syncRoundUp(chatID, id); err != nil {
+		log.Printf("Failed to move round-up of transaction %d: %v", id, err)
+	}
+	sendMessage(chatID, fmt.Sprintf("Transaction #%d moved from %s to %s.", id, t.CreatedAt[:10], date.Format("2006-01-02")))
+	refreshPin(chatID)
+}
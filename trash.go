@@ -0,0 +1,117 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// deleteTransaction moves a transaction to the trash. Rows in the trash
+// are left out of every report and list until restored.
+func deleteTransaction(chatID int64, args string) {
+	cmd := newCommandArgs(args, "/delete <id>")
+	id := cmd.id()
+	if !cmd.ok(chatID) || refuseRoundUpEdit(chatID, id) {
+		return
+	}
+	result, err := db.Exec("UPDATE transactions SET deleted_at = ? WHERE id = ? AND chat_id = ? AND deleted_at IS NULL",
+		time.Now().In(location).Format(timeLayout), id, chatID)
+	if err != nil {
+		sendMessage(chatID, "Failed to delete transaction.")
+		log.Printf("Database exec error: %v", err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		sendMessage(chatID, fmt.Sprintf("Transaction #%d not found.", id))
+		return
+	}
+	if err := syncRoundUp(chatID, id); err != nil {
+		log.Printf("Failed to remove round-up of transaction %d: %v", id, err)
+	}
+
+	text := fmt.Sprintf("Transaction #%d moved to the trash. Undo with /restore %d.", id, id)
+	if TRASH_DAYS > 0 {
+		text += fmt.Sprintf(" It is removed for good after %d day(s).", TRASH_DAYS)
+	}
+	sendMessage(chatID, text)
+	refreshPin(chatID)
+}
+
+func restoreTransaction(chatID int64, args string) {
+	cmd := newCommandArgs(args, "/restore <id>")
+	id := cmd.id()
+	if !cmd.ok(chatID) {
+		return
+	}
+	result, err := db.Exec("UPDATE transactions SET deleted_at = NULL WHERE id = ? AND chat_id = ? AND deleted_at IS NOT NULL", id, chatID)
+	if err != nil {
+		sendMessage(chatID, "Failed to restore transaction.")
+		log.Printf("Database exec error: %v", err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		sendMessage(chatID, fmt.Sprintf("Transaction #%d is not in the trash.", id))
+		return
+	}
+	if err := syncRoundUp(chatID, id); err != nil {
+		log.Printf("Failed to restore round-up of transaction %d: %v", id, err)
+	}
+	sendMessage(chatID, fmt.Sprintf("Transaction #%d restored.", id))
+	refreshPin(chatID)
+}
+
+func showTrash(chatID int64) {
+	transactions, err := queryTransactions("SELECT "+transactionColumns+" FROM transactions WHERE chat_id = ? AND deleted_at IS NOT NULL ORDER BY deleted_at DESC", chatID)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	if len(transactions) == 0 {
+		sendMessage(chatID, "The trash is empty.")
+		return
+	}
+	sendLongMessage(chatID, fmt.Sprintf("Deleted transactions:\n\n%s\nRestore one with /restore <id>, or remove them all with /empty_trash.",
+		formatTransactionList(transactions)))
+}
+
+func emptyTrash(chatID int64, userID int64) {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM transactions WHERE chat_id = ? AND deleted_at IS NOT NULL", chatID).Scan(&count); err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		log.Printf("Database query error: %v", err)
+		return
+	}
+	if count == 0 {
+		sendMessage(chatID, "The trash is empty.")
+		return
+	}
+
+	confirmWithCode(chatID, userID, fmt.Sprintf("remove %d transaction(s) in the trash for good", count), func() {
+		n, err := deleteTransactions(db, "chat_id = ? AND deleted_at IS NOT NULL", chatID)
+		if err != nil {
+			sendMessage(chatID, "Failed to empty the trash.")
+			log.Printf("Database exec error: %v", err)
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("Removed %d transaction(s) for good.", n))
+	})
+}
+
+// runTrashPurge is the scheduled job removing transactions that have been
+// in the trash for more than TRASH_DAYS.
+func runTrashPurge(profile string, conn *sql.DB) error {
+	if TRASH_DAYS <= 0 {
+		return nil
+	}
+	cutoff := time.Now().In(location).AddDate(0, 0, -TRASH_DAYS)
+	n, err := deleteTransactions(conn, "deleted_at IS NOT NULL AND deleted_at < ?", cutoff.Format(timeLayout))
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("Trash purge removed %d transaction(s) from profile %s", n, profile)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// exportUploader stores a finished export file. The local directory
+// writer is the only implementation so far; S3 or e-mail destinations can
+// be added behind the same interface.
+type exportUploader interface {
+	Upload(name string, data []byte) error
+}
+
+// dirUploader writes exports below a local directory.
+type dirUploader struct {
+	dir string
+}
+
+func (u dirUploader) Upload(name string, data []byte) error {
+	path := filepath.Join(u.dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	// Write to a temporary file first so a crash never leaves half a CSV
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// exportDestination receives the monthly exports; nil disables them.
+var exportDestination exportUploader
+
+// runMonthlyExport uploads last month's CSV for every chat of the profile
+// once the month is over. The last exported month is remembered per
+// profile, so the job runs once a month however often it is called.
+func runMonthlyExport(profile string, conn *sql.DB) error {
+	if exportDestination == nil {
+		return nil
+	}
+	now := time.Now().In(location)
+	end := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, location)
+	start := end.AddDate(0, -1, 0)
+	period := start.Format("2006-01")
+	key := "last_export:" + profile
+	if getSetting(globalSettingsUser, key, "") >= period {
+		return nil
+	}
+
+	rows, err := conn.Query("SELECT DISTINCT chat_id FROM transactions WHERE deleted_at IS NULL AND created_at >= ? AND created_at < ?",
+		start.Format(timeLayout), end.Format(timeLayout))
+	if err != nil {
+		return err
+	}
+	var chatIDs []int64
+	for rows.Next() {
+		var chatID int64
+		if err := rows.Scan(&chatID); err != nil {
+			rows.Close()
+			return err
+		}
+		chatIDs = append(chatIDs, chatID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, chatID := range chatIDs {
+		transactions, err := queryTransactionsIn(conn,
+			"SELECT "+transactionColumns+" FROM transactions WHERE chat_id = ? AND deleted_at IS NULL AND created_at >= ? AND created_at < ? ORDER BY created_at",
+			chatID, start.Format(timeLayout), end.Format(timeLayout))
+		if err != nil {
+			return err
+		}
+		data, err := transactionsCSV(transactions)
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(profile, fmt.Sprint(chatID), "transactions_"+period+".csv")
+		if err := exportDestination.Upload(name, data); err != nil {
+			return fmt.Errorf("uploading %s: %w", name, err)
+		}
+	}
+
+	log.Printf("Exported %s for %d chat(s) in profile %s", period, len(chatIDs), profile)
+	return setSetting(globalSettingsUser, key, period)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunMonthlyExport(t *testing.T) {
+	dir := t.TempDir()
+	conn, err := openDatabase(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	savedMain, savedDestination := mainDB, exportDestination
+	defer func() { mainDB, exportDestination = savedMain, savedDestination }()
+	mainDB = conn
+	exportDestination = dirUploader{dir: filepath.Join(dir, "exports")}
+
+	now := time.Now().In(location)
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, location)
+	lastMonth := thisMonth.AddDate(0, -1, 0)
+	inLastMonth := lastMonth.AddDate(0, 0, 1).Add(10 * time.Hour).Format(timeLayout)
+	for _, row := range []struct {
+		createdAt   string
+		description string
+		deleted     interface{}
+	}{
+		{inLastMonth, "lunch", nil},
+		{inLastMonth, "trashed", inLastMonth},
+		{thisMonth.Add(time.Hour).Format(timeLayout), "this month", nil},
+	} {
+		if _, err := conn.Exec("INSERT INTO transactions (type, category, amount, description, created_at, chat_id, deleted_at) VALUES ('expense', 'Food', 25000, ?, ?, 42, ?)",
+			row.description, row.createdAt, row.deleted); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := runMonthlyExport("default", conn); err != nil {
+		t.Fatalf("runMonthlyExport returned error: %v", err)
+	}
+	path := filepath.Join(dir, "exports", "default", "42", "transactions_"+lastMonth.Format("2006-01")+".csv")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "id,created_at,type,category,amount,description,reference\n" +
+		"1," + inLastMonth + ",expense,Food,25000,lunch,\n"
+	if string(data) != want {
+		t.Errorf("exported CSV = %q, want %q", data, want)
+	}
+
+	// The month is remembered, so a second run doesn't export it again
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := runMonthlyExport("default", conn); err != nil {
+		t.Fatalf("second runMonthlyExport returned error: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("second run exported %s again", path)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// speechTranscriber turns a voice message into text. httpTranscriber is
+// the only implementation so far; others can be added behind the same
+// interface.
+type speechTranscriber interface {
+	Transcribe(audio []byte, mimeType string) (string, error)
+}
+
+// httpTranscriber posts audio to an OpenAI-compatible
+// /audio/transcriptions endpoint.
+type httpTranscriber struct {
+	url    string
+	apiKey string
+	model  string
+	client *http.Client
+}
+
+func newHTTPTranscriber(url, apiKey, model string) httpTranscriber {
+	if model == "" {
+		model = "whisper-1"
+	}
+	return httpTranscriber{url: url, apiKey: apiKey, model: model, client: &http.Client{Timeout: 60 * time.Second}}
+}
+
+func (t httpTranscriber) Transcribe(audio []byte, mimeType string) (string, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	if err := w.WriteField("model", t.model); err != nil {
+		return "", err
+	}
+	// Telegram voice notes are Ogg/Opus
+	part, err := w.CreateFormFile("file", "voice.ogg")
+	if err != nil {
+		return "", err
+	}
+	if _, err := part.Write(audio); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.url, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	if t.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+t.apiKey)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("transcription service returned %s", resp.Status)
+	}
+
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(result.Text) == "" {
+		return "", errors.New("empty transcription")
+	}
+	return result.Text, nil
+}
+
+// transcriber handles voice messages; nil disables them.
+var transcriber speechTranscriber
+
+// maxVoiceSeconds keeps transcription requests short and cheap.
+const maxVoiceSeconds = 60
+
+// downloadFile fetches a file sent to the bot.
+func downloadFile(fileID string) ([]byte, error) {
+	url, err := bot.GetFileDirectURL(fileID)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download returned %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// parseSpokenEntry splits a transcript such as "25,000 food lunch with
+// Budi" into the amount text, a category if one of the words names one,
+// and the remaining words as the description.
+func parseSpokenEntry(text string) (amount, category, description string) {
+	// Transcripts often contain separators, as in "25,000"
+	text = stripThousandsSeparators(text)
+	var rest []string
+	for _, word := range strings.Fields(text) {
+		trimmed := strings.Trim(word, ".,!?")
+		if amount == "" && strings.IndexAny(trimmed, "0123456789") >= 0 {
+			amount = trimmed
+			continue
+		}
+		if category == "" {
+			if c, ok := findCategory(trimmed); ok {
+				category = c
+				continue
+			}
+		}
+		rest = append(rest, word)
+	}
+	return amount, category, strings.TrimSpace(strings.Trim(strings.Join(rest, " "), "."))
+}
+
+// voiceResult carries a transcript from the goroutine that fetched it back
+// to the update loop.
+type voiceResult struct {
+	message *tgbotapi.Message
+	state   *TransactionState
+	text    string
+	err     error
+}
+
+// voiceResults is read by the update loop in main.
+var voiceResults = make(chan voiceResult)
+
+// processVoiceAmount starts transcribing a voice message sent at the
+// amount step. Downloading and transcribing can take a while, so they run
+// in the background and applyVoiceAmount picks the result up.
+func processVoiceAmount(message *tgbotapi.Message, state *TransactionState) {
+	chatID := message.Chat.ID
+	if transcriber == nil {
+		sendMessage(chatID, "Voice messages aren't enabled. Please type the amount.")
+		return
+	}
+	if message.Voice.Duration > maxVoiceSeconds {
+		sendMessage(chatID, fmt.Sprintf("Please keep voice messages under %d seconds, or type the amount.", maxVoiceSeconds))
+		return
+	}
+
+	fileID, mimeType := message.Voice.FileID, message.Voice.MimeType
+	go func() {
+		audio, err := downloadFile(fileID)
+		var text string
+		if err == nil {
+			text, err = transcriber.Transcribe(audio, mimeType)
+		}
+		voiceResults <- voiceResult{message: message, state: state, text: text, err: err}
+	}()
+}
+
+// applyVoiceAmount continues the flow as if the transcribed amount, and
+// any spoken description, had been typed. A spoken category replaces the
+// selected one. Transcripts arriving after the flow moved on are dropped.
+func applyVoiceAmount(result voiceResult) {
+	chatID, state := result.message.Chat.ID, result.state
+	if userStates[stateKey{chatID, state.UserID}] != state || state.Step != "ENTER_AMOUNT" {
+		log.Printf("Dropped voice transcript for chat %d: the transaction moved on", chatID)
+		return
+	}
+	if result.err != nil {
+		log.Printf("Voice transcription failed: %v", result.err)
+		sendMessage(chatID, "Sorry, I couldn't understand that voice message. Please type the amount.")
+		return
+	}
+
+	text := result.text
+	amount, category, description := parseSpokenEntry(text)
+	if amount == "" {
+		sendMessage(chatID, fmt.Sprintf("I heard %q but found no amount. Please type the amount.", text))
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("Heard: %q", text))
+	if category != "" {
+		state.Category = category
+	}
+
+	typed := *result.message
+	typed.Text = amount
+	processAmount(&typed, state)
+	if description != "" && state.Step == "ENTER_DESCRIPTION" {
+		typed.Text = description
+		processDescription(&typed, state)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestParseSpokenEntry(t *testing.T) {
+	saved := categories
+	defer func() { categories = saved }()
+	categories = []string{"Food", "Transport"}
+
+	tests := []struct {
+		transcript                    string
+		amount, category, description string
+	}{
+		{"25,000 food lunch with Budi.", "25000", "Food", "lunch with Budi"},
+		{"Lunch 25.000", "25000", "", "Lunch"},
+		{"transport 1,250,000 flight home", "1250000", "Transport", "flight home"},
+		{"12.5 coffee", "12.5", "", "coffee"},
+		{"three thousand for coffee", "", "", "three thousand for coffee"},
+	}
+	for _, tt := range tests {
+		amount, category, description := parseSpokenEntry(tt.transcript)
+		if amount != tt.amount || category != tt.category || description != tt.description {
+			t.Errorf("parseSpokenEntry(%q) = %q, %q, %q, want %q, %q, %q", tt.transcript,
+				amount, category, description, tt.amount, tt.category, tt.description)
+		}
+	}
+}
+
+func TestParseSpokenEntryAmount(t *testing.T) {
+	saved := categories
+	defer func() { categories = saved }()
+	categories = []string{"Food"}
+
+	amount, _, _ := parseSpokenEntry("50,000 food")
+	got, err := parseAmount(amount)
+	if err != nil {
+		t.Fatalf("parseAmount(%q) returned error: %v", amount, err)
+	}
+	if got != 50000 {
+		t.Errorf("parseAmount(%q) = %v, want 50000", amount, got)
+	}
+}
+
+// A transcript that arrives after the transaction moved on must not touch it.
+func TestApplyVoiceAmountAfterFlowMovedOn(t *testing.T) {
+	message := &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: 1}}
+	tests := []struct {
+		name   string
+		state  *TransactionState
+		stored bool
+	}{
+		{"cancelled", &TransactionState{ChatID: 1, UserID: 2, Step: "ENTER_AMOUNT"}, false},
+		{"past the amount", &TransactionState{ChatID: 1, UserID: 2, Step: "ENTER_DESCRIPTION", Amount: 5000}, true},
+	}
+	for _, tt := range tests {
+		if tt.stored {
+			userStates[stateKey{1, 2}] = tt.state
+		}
+		before := *tt.state
+		applyVoiceAmount(voiceResult{message: message, state: tt.state, text: "25000 lunch"})
+		delete(userStates, stateKey{1, 2})
+		if tt.state.Step != before.Step || tt.state.Amount != before.Amount || tt.state.Description != before.Description {
+			t.Errorf("%s: state changed to %+v", tt.name, *tt.state)
+		}
+	}
+}